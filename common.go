@@ -17,6 +17,6 @@ var verboseLevel = 0
 
 func logVerbose(level int, v ...interface{}) {
 	if level <= verboseLevel {
-		log.Println(v)
+		log.Println(v...)
 	}
 }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ExtractorFunc returns the links found in page. doc is nil for non html
+// pages.
+type ExtractorFunc func(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string
+
+var linkExtractors = map[string]ExtractorFunc{
+	"anchors":          extractAnchors,
+	"srcset":           extractSrcset,
+	"meta-refresh":     extractMetaRefresh,
+	"link-header":      extractLinkHeader,
+	"content-location": extractContentLocation,
+	"css":              extractCSSUrls,
+	"forms":            extractFormActions,
+	"iframes":          extractIframes,
+	"data-attributes":  extractDataAttributes,
+	"redirects":        extractRedirects,
+}
+
+type extractorStat struct {
+	Pages int
+	Found int
+	New   int
+}
+
+var regCSSUrl = regexp.MustCompile(`url\(\s*['"]?([^'")\s]+)['"]?\s*\)`)
+var regCSSImport = regexp.MustCompile(`@import\s+['"]([^'"]+)['"]`)
+var regMetaRefreshURL = regexp.MustCompile(`(?i)url\s*=\s*['"]?([^'"]+)['"]?`)
+var regLinkHeader = regexp.MustCompile(`<([^>]+)>`)
+
+// parseExtractorNames parses the -extractors flag, e.g. "anchors,css" or "all".
+func parseExtractorNames(list string) ([]string, error) {
+	names := []string{}
+	if strings.TrimSpace(list) == "all" {
+		for name := range linkExtractors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names, nil
+	}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := linkExtractors[name]; !ok {
+			return nil, errors.New("extractor " + name + " not found")
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// extractLinks runs all enabled extractors on page and returns the
+// absolute, deduplicated links.
+func extractLinks(settings *crawlSettings, cw *crawlbase.Crawler, page *crawlbase.Page) []string {
+	base, err := url.Parse(page.URL)
+	if err != nil {
+		return nil
+	}
+
+	var doc *goquery.Document
+	if page.Response != nil && page.Response.Header != nil &&
+		crawlbase.GetContentMime(page.Response.Header) == "text/html" &&
+		len(page.ResponseBody) > 0 {
+		doc, err = goquery.NewDocumentFromReader(bytes.NewReader(page.ResponseBody))
+		if err != nil {
+			logVerbose(1, "extractLinks:", err)
+			doc = nil
+		}
+	}
+
+	seen := map[string]bool{}
+	links := []string{}
+	for _, name := range settings.Extractors {
+		found := 0
+		newLinks := 0
+		for _, link := range linkExtractors[name](page, base, doc) {
+			link = toCrawlableURL(base, link)
+			if link == "" {
+				continue
+			}
+			found++
+			if seen[link] {
+				continue
+			}
+			seen[link] = true
+			links = append(links, link)
+
+			key := link
+			if settings.Normalizer != nil {
+				key = settings.Normalizer.Canonical(link)
+			}
+			if _, known := cw.Links[key]; !known {
+				newLinks++
+			}
+		}
+
+		if settings.State.Fetching() {
+			stat, ok := settings.ExtractorStats[name]
+			if !ok {
+				stat = &extractorStat{}
+				settings.ExtractorStats[name] = stat
+			}
+			stat.Pages++
+			stat.Found += found
+			stat.New += newLinks
+		}
+	}
+	return links
+}
+
+// toCrawlableURL resolves link against base. Returns "" for links the
+// crawler can't fetch (javascript:, mailto:, data:, ...).
+func toCrawlableURL(base *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || strings.HasPrefix(link, "#") {
+		return ""
+	}
+	abs := crawlbase.ToAbsUrl(base, link)
+	u, err := url.Parse(abs)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return abs
+}
+
+func extractAnchors(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	return page.RespInfo.Hrefs
+}
+
+func extractSrcset(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	links := []string{}
+	if doc == nil {
+		return links
+	}
+	doc.Find("img[srcset], source[srcset]").Each(func(i int, s *goquery.Selection) {
+		srcset, _ := s.Attr("srcset")
+		// "small.jpg 480w, large.jpg 1080w"
+		for _, candidate := range strings.Split(srcset, ",") {
+			fields := strings.Fields(candidate)
+			if len(fields) > 0 {
+				links = append(links, fields[0])
+			}
+		}
+	})
+	return links
+}
+
+func extractMetaRefresh(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	links := []string{}
+	if doc == nil {
+		return links
+	}
+	doc.Find("meta[http-equiv]").Each(func(i int, s *goquery.Selection) {
+		equiv, _ := s.Attr("http-equiv")
+		if !strings.EqualFold(equiv, "refresh") {
+			return
+		}
+		// "5; url=/next"
+		content, _ := s.Attr("content")
+		match := regMetaRefreshURL.FindStringSubmatch(content)
+		if len(match) > 1 {
+			links = append(links, match[1])
+		}
+	})
+	return links
+}
+
+func extractLinkHeader(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	links := []string{}
+	if page.Response == nil {
+		return links
+	}
+	// Link: <https://example.com/style.css>; rel=preload, </page/2>; rel=next
+	for _, val := range page.Response.Header["Link"] {
+		for _, match := range regLinkHeader.FindAllStringSubmatch(val, -1) {
+			links = append(links, match[1])
+		}
+	}
+	return links
+}
+
+func extractContentLocation(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	if page.Response == nil {
+		return nil
+	}
+	return page.Response.Header["Content-Location"]
+}
+
+func extractCSSUrls(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	var css []string
+	if doc != nil {
+		doc.Find("style").Each(func(i int, s *goquery.Selection) {
+			css = append(css, s.Text())
+		})
+		doc.Find("[style]").Each(func(i int, s *goquery.Selection) {
+			style, _ := s.Attr("style")
+			css = append(css, style)
+		})
+	} else if page.Response != nil && page.Response.Header != nil &&
+		crawlbase.GetContentMime(page.Response.Header) == "text/css" {
+		css = append(css, string(page.ResponseBody))
+	}
+
+	links := []string{}
+	for _, text := range css {
+		for _, match := range regCSSUrl.FindAllStringSubmatch(text, -1) {
+			links = append(links, match[1])
+		}
+		for _, match := range regCSSImport.FindAllStringSubmatch(text, -1) {
+			links = append(links, match[1])
+		}
+	}
+	return links
+}
+
+func extractFormActions(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	links := []string{}
+	for _, form := range page.RespInfo.Forms {
+		links = append(links, form.Url)
+	}
+	return links
+}
+
+func extractIframes(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	links := []string{}
+	if doc == nil {
+		return links
+	}
+	doc.Find("iframe[src], frame[src]").Each(func(i int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		links = append(links, src)
+	})
+	return links
+}
+
+func extractDataAttributes(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	links := []string{}
+	if doc == nil {
+		return links
+	}
+	doc.Find("*").Each(func(i int, s *goquery.Selection) {
+		for _, attr := range s.Nodes[0].Attr {
+			if strings.HasPrefix(attr.Key, "data-") && looksLikeURL(attr.Val) {
+				links = append(links, attr.Val)
+			}
+		}
+	})
+	return links
+}
+
+func looksLikeURL(val string) bool {
+	if val == "" || strings.ContainsAny(val, " \t\n<>{}\"") {
+		return false
+	}
+	return strings.HasPrefix(val, "http://") || strings.HasPrefix(val, "https://") ||
+		strings.HasPrefix(val, "/") || strings.HasPrefix(val, "./") ||
+		strings.HasPrefix(val, "../")
+}
+
+func extractRedirects(page *crawlbase.Page, base *url.URL, doc *goquery.Document) []string {
+	if page.Response == nil || page.Response.Header == nil {
+		return nil
+	}
+	return page.Response.Header["Location"]
+}
+
+// writeExtractorStats logs and writes extractorstats.csv to the storage
+// folder.
+func writeExtractorStats(settings *crawlSettings) {
+	if len(settings.ExtractorStats) == 0 {
+		return
+	}
+
+	for _, name := range settings.Extractors {
+		if stat, ok := settings.ExtractorStats[name]; ok {
+			log.Println("extractor", name+":", stat.Found, "link(s),", stat.New, "new")
+		}
+	}
+
+	if settings.StorageFolder == "" {
+		return
+	}
+	file, err := os.Create(settings.StorageFolder + "/extractorstats.csv")
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"extractor", "pages", "links found", "new links"})
+	for _, name := range settings.Extractors {
+		stat, ok := settings.ExtractorStats[name]
+		if !ok {
+			continue
+		}
+		csv.Write([]string{name, strconv.Itoa(stat.Pages),
+			strconv.Itoa(stat.Found), strconv.Itoa(stat.New)})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+// crawlIdentity sets the per request headers of the crawler: a rotating
+// user agent and a unique tag to find the crawler's requests in the
+// target's logs.
+type crawlIdentity struct {
+	UserAgents []string
+	TagHeader  string
+	RunID      string
+	requests   int
+}
+
+func newCrawlIdentity() *crawlIdentity {
+	id := &crawlIdentity{}
+	id.RunID = strconv.FormatInt(time.Now().Unix(), 36)
+	return id
+}
+
+// Apply updates the crawler headers before the next request.
+func (id *crawlIdentity) Apply(header http.Header) {
+	if len(id.UserAgents) > 0 {
+		header.Set("User-Agent", id.UserAgents[id.requests%len(id.UserAgents)])
+	}
+	if id.TagHeader != "" {
+		header.Set(id.TagHeader, "ncrawler-"+id.RunID+"-"+strconv.Itoa(id.requests))
+	}
+}
+
+// Sent advances the rotation after a request was sent with the applied
+// headers.
+func (id *crawlIdentity) Sent() {
+	id.requests++
+}
+
+// hostTransport overwrites the Host of requests to the target hosts.
+// net/http ignores a Host entry in the header map.
+type hostTransport struct {
+	Base    http.RoundTripper
+	Host    string
+	Targets map[string]bool
+}
+
+// AddTarget adds a host whose requests get the Host header.
+func (t *hostTransport) AddTarget(host string) {
+	t.Targets[strings.ToLower(host)] = true
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.Targets[strings.ToLower(req.URL.Host)] {
+		return t.Base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Host = t.Host
+	return t.Base.RoundTrip(req)
+}
+
+// setCrawlerHeaders applies the -H headers to the crawler. A Host header is
+// moved to the returned transport, nil if there is none. It is only sent
+// to the hosts added with AddTarget.
+func setCrawlerHeaders(cw *crawlbase.Crawler, headers []string) *hostTransport {
+	applyHeaders(cw.Header, headers)
+
+	host := cw.Header.Get("Host")
+	if host == "" {
+		return nil
+	}
+	cw.Header.Del("Host")
+	base := cw.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &hostTransport{Base: base, Host: host, Targets: map[string]bool{}}
+	cw.Client.Transport = t
+	return t
+}
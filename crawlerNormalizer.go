@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// normalizeRules configures how urls are canonicalized before they are
+// added to the crawler's link map. Loaded from config/normalize.json.
+type normalizeRules struct {
+	SortParams          bool
+	LowercaseHost       bool
+	RemoveDefaultPort   bool
+	RemoveFragment      bool
+	RemoveTrailingSlash bool
+	DropParams          []string
+	DropParamPrefixes   []string
+}
+
+type urlNormalizer struct {
+	Rules     normalizeRules
+	Originals map[string][]string // canonical url -> all seen original urls
+	MapFile   string
+	dropped   map[string]bool
+	canonical map[string]string // original url -> canonical url
+	mapFile   *os.File
+	mapWriter *csv.Writer
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+func loadNormalizeRules(path string) (*normalizeRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &normalizeRules{}
+	err = json.Unmarshal(data, rules)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func newURLNormalizer(rules normalizeRules) *urlNormalizer {
+	n := &urlNormalizer{}
+	n.Rules = rules
+	n.Originals = map[string][]string{}
+	n.dropped = map[string]bool{}
+	n.canonical = map[string]string{}
+	for _, p := range rules.DropParams {
+		n.dropped[strings.ToLower(p)] = true
+	}
+	return n
+}
+
+func (n *urlNormalizer) isDroppedParam(name string) bool {
+	name = strings.ToLower(name)
+	if n.dropped[name] {
+		return true
+	}
+	for _, prefix := range n.Rules.DropParamPrefixes {
+		if strings.HasPrefix(name, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Canonical returns the canonical form of rawURL. Urls which can't be
+// parsed or are not absolute are returned unchanged.
+func (n *urlNormalizer) Canonical(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() {
+		return rawURL
+	}
+
+	// url.Parse lowercases the scheme, keep it as written unless hosts
+	// are lowercased too
+	if !n.Rules.LowercaseHost {
+		u.Scheme = rawURL[:len(u.Scheme)]
+	}
+
+	host := u.Host
+	if n.Rules.LowercaseHost {
+		host = strings.ToLower(host)
+	}
+	if n.Rules.RemoveDefaultPort {
+		port := u.Port()
+		if port != "" && defaultPorts[strings.ToLower(u.Scheme)] == port {
+			host = strings.TrimSuffix(host, ":"+port)
+		}
+	}
+	u.Host = host
+
+	if n.Rules.RemoveFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	// the path is edited escaped, so an escaped slash or semicolon stays
+	// part of its segment
+	escapedPath := u.EscapedPath()
+
+	// session ids in path parameters, e.g. /index.jsp;jsessionid=ABC
+	if strings.Contains(escapedPath, ";") {
+		segments := strings.Split(escapedPath, "/")
+		for i, seg := range segments {
+			parts := strings.Split(seg, ";")
+			kept := parts[:1]
+			for _, p := range parts[1:] {
+				name := strings.SplitN(p, "=", 2)[0]
+				if unescaped, err := url.PathUnescape(name); err == nil {
+					name = unescaped
+				}
+				if !n.isDroppedParam(name) {
+					kept = append(kept, p)
+				}
+			}
+			segments[i] = strings.Join(kept, ";")
+		}
+		escapedPath = strings.Join(segments, "/")
+	}
+
+	if n.Rules.RemoveTrailingSlash && len(escapedPath) > 1 {
+		escapedPath = strings.TrimRight(escapedPath, "/")
+	}
+
+	if escapedPath != u.EscapedPath() {
+		u.Path, err = url.PathUnescape(escapedPath)
+		if err != nil {
+			return rawURL
+		}
+		u.RawPath = escapedPath
+	}
+
+	if u.RawQuery != "" {
+		u.RawQuery = n.canonicalQuery(u.RawQuery)
+	}
+	u.ForceQuery = false
+
+	return u.String()
+}
+
+func (n *urlNormalizer) canonicalQuery(rawQuery string) string {
+	params := strings.Split(rawQuery, "&")
+	kept := []string{}
+	for _, p := range params {
+		if p == "" {
+			continue
+		}
+		name := strings.SplitN(p, "=", 2)[0]
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if n.isDroppedParam(name) {
+			continue
+		}
+		kept = append(kept, p)
+	}
+	if n.Rules.SortParams {
+		sort.Strings(kept)
+	}
+	return strings.Join(kept, "&")
+}
+
+// Add returns the canonical url and remembers every original url of it,
+// including the canonical url itself.
+func (n *urlNormalizer) Add(rawURL string) string {
+	canonical := n.Canonical(rawURL)
+	if n.addOriginal(canonical, rawURL) {
+		n.appendToMapFile(canonical, rawURL)
+	}
+	return canonical
+}
+
+// addOriginal returns false if original is already known.
+func (n *urlNormalizer) addOriginal(canonical, original string) bool {
+	for _, o := range n.Originals[canonical] {
+		if o == original {
+			return false
+		}
+	}
+	n.Originals[canonical] = append(n.Originals[canonical], original)
+	n.canonical[original] = canonical
+	return true
+}
+
+// Lookup returns the recorded canonical url of rawURL, urls not seen by
+// the crawler are canonicalized by the rules.
+func (n *urlNormalizer) Lookup(rawURL string) string {
+	if canonical, ok := n.canonical[rawURL]; ok {
+		return canonical
+	}
+	return n.Canonical(rawURL)
+}
+
+func (n *urlNormalizer) AddAll(urls []string) []string {
+	canonicals := make([]string, 0, len(urls))
+	for _, u := range urls {
+		canonicals = append(canonicals, n.Add(u))
+	}
+	return canonicals
+}
+
+// LoadMapFile reads the canonical to original mappings of earlier runs,
+// so they are not written twice.
+func (n *urlNormalizer) LoadMapFile(path string) error {
+	n.MapFile = path
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	csv := csv.NewReader(f)
+	csv.Comma = ';'
+	csv.FieldsPerRecord = 2
+	records, err := csv.ReadAll()
+	if err != nil {
+		return err
+	}
+	for _, r := range records {
+		n.addOriginal(r[0], r[1])
+	}
+	return nil
+}
+
+// appendToMapFile writes one mapping to MapFile, which is kept open until
+// Close is called.
+func (n *urlNormalizer) appendToMapFile(canonical, original string) {
+	if n.MapFile == "" {
+		return
+	}
+	if n.mapFile == nil {
+		f, err := os.OpenFile(n.MapFile, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			logError(err)
+			return
+		}
+		n.mapFile = f
+		n.mapWriter = csv.NewWriter(f)
+		n.mapWriter.Comma = ';'
+	}
+
+	n.mapWriter.Write([]string{canonical, original})
+	n.mapWriter.Flush()
+	logError(n.mapWriter.Error())
+}
+
+// Close closes MapFile if it was opened.
+func (n *urlNormalizer) Close() error {
+	if n.mapFile == nil {
+		return nil
+	}
+	err := n.mapFile.Close()
+	n.mapFile = nil
+	n.mapWriter = nil
+	return err
+}
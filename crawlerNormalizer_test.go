@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testNormalizeRules = normalizeRules{
+	SortParams:          true,
+	LowercaseHost:       true,
+	RemoveDefaultPort:   true,
+	RemoveFragment:      true,
+	RemoveTrailingSlash: true,
+	DropParams:          []string{"jsessionid", "sid"},
+	DropParamPrefixes:   []string{"utm_"},
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"unchanged", "http://example.com/a?x=1", "http://example.com/a?x=1"},
+		{"lowercase host and scheme", "HTTP://Example.COM/Path", "http://example.com/Path"},
+		{"default http port", "http://example.com:80/a", "http://example.com/a"},
+		{"default https port", "https://example.com:443/a", "https://example.com/a"},
+		{"other port kept", "http://example.com:8080/a", "http://example.com:8080/a"},
+		{"port of other scheme kept", "https://example.com:80/a", "https://example.com:80/a"},
+		{"fragment", "http://example.com/a#top", "http://example.com/a"},
+		{"trailing slash", "http://example.com/a/", "http://example.com/a"},
+		{"root slash kept", "http://example.com/", "http://example.com/"},
+		{"escaped slash kept", "http://example.com/a%2Fb/", "http://example.com/a%2Fb"},
+		{"escaped chars kept", "http://example.com/a%2F%3Bb;jsessionid=1", "http://example.com/a%2F%3Bb"},
+		{"sorted params", "http://example.com/?b=2&a=1", "http://example.com/?a=1&b=2"},
+		{"dropped param", "http://example.com/?sid=1&a=1", "http://example.com/?a=1"},
+		{"dropped param case", "http://example.com/?SID=1&a=1", "http://example.com/?a=1"},
+		{"dropped prefix", "http://example.com/?utm_source=x&utm_medium=y", "http://example.com/"},
+		{"escaped dropped param", "http://example.com/?%73id=1&a=1", "http://example.com/?a=1"},
+		{"empty params", "http://example.com/?a=1&&b=2", "http://example.com/?a=1&b=2"},
+		{"path session id", "http://example.com/index.jsp;jsessionid=ABC", "http://example.com/index.jsp"},
+		{"path param kept", "http://example.com/a;v=1", "http://example.com/a;v=1"},
+		{"escaped path session id", "http://example.com/a;%73id=1", "http://example.com/a"},
+		{"relative url unchanged", "/a/?b=2&a=1", "/a/?b=2&a=1"},
+	}
+	n := newURLNormalizer(testNormalizeRules)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := n.Canonical(tt.url); got != tt.want {
+				t.Errorf("Canonical(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalRulesDisabled(t *testing.T) {
+	n := newURLNormalizer(normalizeRules{})
+	url := "HTTP://Example.com:80/a%2F/?b=2&a=1#top"
+	if got := n.Canonical(url); got != url {
+		t.Errorf("Canonical(%q) = %q, want it unchanged", url, got)
+	}
+}
+
+func TestAddRecordsOriginals(t *testing.T) {
+	tests := []struct {
+		name      string
+		urls      []string
+		originals map[string][]string
+	}{
+		{
+			"canonical url itself",
+			[]string{"http://example.com/a"},
+			map[string][]string{"http://example.com/a": {"http://example.com/a"}},
+		},
+		{
+			"every original",
+			[]string{"http://example.com/a/", "http://example.com/a#x", "http://example.com/a/"},
+			map[string][]string{"http://example.com/a": {"http://example.com/a/", "http://example.com/a#x"}},
+		},
+		{
+			"separate canonicals",
+			[]string{"http://example.com/?b=1&a=1", "http://example.com/b"},
+			map[string][]string{
+				"http://example.com/?a=1&b=1": {"http://example.com/?b=1&a=1"},
+				"http://example.com/b":        {"http://example.com/b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newURLNormalizer(testNormalizeRules)
+			n.AddAll(tt.urls)
+			if !reflect.DeepEqual(n.Originals, tt.originals) {
+				t.Errorf("Originals = %v, want %v", n.Originals, tt.originals)
+			}
+		})
+	}
+}
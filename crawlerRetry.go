@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+// failure classes of a fetched page
+const (
+	failDNS      = "dns"
+	failConnect  = "connect"
+	failTimeout  = "timeout"
+	failTLS      = "tls"
+	failServer   = "5xx"
+	failThrottle = "429"
+	failOther    = "other"
+)
+
+const maxBackoff = 5 * time.Minute
+const maxHostDelay = time.Minute
+const minHostDelay = time.Second
+
+type crawlFailure struct {
+	URL        string
+	Class      string
+	Attempts   int
+	StatusCode int
+	Error      string
+}
+
+// hostThrottle adds a per host delay between requests. The delay grows
+// every time a host responds with 429 or a Retry-After header and shrinks
+// again with successful responses.
+type hostThrottle struct {
+	Delays      map[string]time.Duration
+	lastRequest map[string]time.Time
+}
+
+func newHostThrottle() *hostThrottle {
+	t := &hostThrottle{}
+	t.Delays = map[string]time.Duration{}
+	t.lastRequest = map[string]time.Time{}
+	return t
+}
+
+// Wait sleeps until the host delay since the last request to host passed.
+// Returns false if sleep was interrupted.
+func (t *hostThrottle) Wait(host string, sleep func(time.Duration) bool) bool {
+	delay := t.Delays[host]
+	if last, ok := t.lastRequest[host]; ok && delay > 0 {
+		if since := time.Since(last); since < delay && !sleep(delay-since) {
+			return false
+		}
+	}
+	t.lastRequest[host] = time.Now()
+	return true
+}
+
+// SlowDown doubles the delay of host, at least to min.
+func (t *hostThrottle) SlowDown(host string, min time.Duration) {
+	delay := t.Delays[host] * 2
+	if delay == 0 {
+		delay = time.Second
+	}
+	if delay < min {
+		delay = min
+	}
+	if delay > maxHostDelay {
+		delay = maxHostDelay
+	}
+	t.Delays[host] = delay
+	log.Println("throttling detected, delay for", host, "is now", delay)
+}
+
+// SpeedUp halves the delay of host after a successful response, below
+// minHostDelay the host is not throttled anymore.
+func (t *hostThrottle) SpeedUp(host string) {
+	delay, ok := t.Delays[host]
+	if !ok {
+		return
+	}
+	delay /= 2
+	if delay < minHostDelay {
+		delete(t.Delays, host)
+		log.Println("throttling for", host, "removed")
+		return
+	}
+	t.Delays[host] = delay
+}
+
+// classifyFailure returns the failure class of a page and whether it is
+// worth retrying. An empty class means the page was fetched successfully.
+func classifyFailure(page *crawlbase.Page, err error) (string, bool) {
+	if err != nil || page.Error != "" {
+		return classifyError(err, page.Error)
+	}
+	if page.Response == nil {
+		return "", false
+	}
+
+	code := page.Response.StatusCode
+	switch {
+	case code == 429:
+		return failThrottle, true
+	case code == 501 || code == 505:
+		return failServer, false
+	case code >= 500:
+		return failServer, true
+	}
+	return "", false
+}
+
+func classifyError(err error, msg string) (string, bool) {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return failDNS, dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return failTimeout, true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return failConnect, true
+	}
+	// connection closed by the server before a complete response
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return failConnect, true
+	}
+
+	// stored pages only have the error text
+	if msg == "" && err != nil {
+		msg = err.Error()
+	}
+	msg = strings.ToLower(msg)
+	switch {
+	case strings.Contains(msg, "no such host"):
+		return failDNS, false
+	case strings.Contains(msg, "server misbehaving"):
+		return failDNS, true
+	case strings.Contains(msg, "timeout") ||
+		strings.Contains(msg, "deadline exceeded"):
+		return failTimeout, true
+	case strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:"):
+		return failTLS, false
+	case strings.Contains(msg, "connection refused") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "no route to host") ||
+		strings.Contains(msg, "network is unreachable"):
+		return failConnect, true
+	}
+	return failOther, false
+}
+
+// retryAfter parses the Retry-After header, either seconds or a http date.
+// The delay is capped at maxBackoff.
+func retryAfter(header http.Header) time.Duration {
+	val := strings.TrimSpace(header.Get("Retry-After"))
+	if val == "" {
+		return 0
+	}
+	var wait time.Duration
+	// out of range values are clamped to the int64 bounds by ParseInt
+	if secs, err := strconv.ParseInt(val, 10, 64); err == nil || errors.Is(err, strconv.ErrRange) {
+		if secs > int64(maxBackoff/time.Second) {
+			return maxBackoff
+		}
+		wait = time.Duration(secs) * time.Second
+	} else if date, err := http.ParseTime(val); err == nil {
+		wait = time.Until(date)
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxBackoff {
+		return maxBackoff
+	}
+	return wait
+}
+
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// handleFailure records a failed page and puts it back into the frontier
+// if it's retryable and has attempts left. Returns true if the page will
+// be retried.
+func handleFailure(settings *crawlSettings, cw *crawlbase.Crawler,
+	page *crawlbase.Page, err error) bool {
+	class, retryable := classifyFailure(page, err)
+	state := settings.State
+	if class == "" {
+		if _, failed := state.Failures[page.URL]; failed {
+			log.Println("recovered after retry:", page.URL)
+			delete(state.Failures, page.URL)
+		}
+		if pURL, err := url.Parse(page.URL); err == nil {
+			settings.Throttle.SpeedUp(pURL.Host)
+		}
+		return false
+	}
+
+	state.Retries[page.URL]++
+	attempts := state.Retries[page.URL]
+
+	failure := &crawlFailure{URL: page.URL, Class: class, Attempts: attempts}
+	failure.Error = page.Error
+	if page.Response != nil {
+		failure.StatusCode = page.Response.StatusCode
+	}
+	state.Failures[page.URL] = failure
+
+	var wait time.Duration
+	if page.Response != nil && page.Response.Header != nil {
+		wait = retryAfter(page.Response.Header)
+	}
+	if pURL, err := url.Parse(page.URL); err == nil &&
+		(class == failThrottle || wait > 0) {
+		settings.Throttle.SlowDown(pURL.Host, wait)
+	}
+
+	if !retryable || attempts > settings.MaxRetries {
+		return false
+	}
+	if backoff := backoffDelay(settings.Backoff, attempts); backoff > wait {
+		wait = backoff
+	}
+	log.Println("retrying", page.URL, "("+class+") in", wait)
+	state.Backoff[page.URL] = time.Now().Add(wait).UnixNano()
+	cw.Links[page.URL] = false
+	return true
+}
+
+// nextDueLink returns link if it's not waiting for a retry. Otherwise
+// another uncrawled link is used, or it waits until link is due or the
+// crawl is interrupted.
+func nextDueLink(state *crawlState, cw *crawlbase.Crawler, link string) string {
+	due, ok := state.Backoff[link]
+	if !ok {
+		return link
+	}
+	now := time.Now().UnixNano()
+	if now < due {
+		for other, crawled := range cw.Links {
+			if crawled || other == link {
+				continue
+			}
+			if otherDue, ok := state.Backoff[other]; ok && now < otherDue {
+				continue
+			}
+			return other
+		}
+		if !state.Sleep(time.Duration(due - now)) {
+			return link
+		}
+	}
+	delete(state.Backoff, link)
+	return link
+}
+
+// writeFailureSummary logs failures by class and writes them to
+// crawlfailures.csv in the storage folder.
+func writeFailureSummary(settings *crawlSettings) {
+	failures := settings.State.Failures
+	if len(failures) == 0 {
+		return
+	}
+
+	byClass := map[string]int{}
+	urls := []string{}
+	for u, f := range failures {
+		byClass[f.Class]++
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+	for class, count := range byClass {
+		log.Println("failed pages,", class+":", count)
+	}
+
+	if settings.StorageFolder == "" {
+		return
+	}
+	file, err := os.Create(settings.StorageFolder + "/crawlfailures.csv")
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"url", "class", "attempts", "Http code", "error"})
+	for _, u := range urls {
+		f := failures[u]
+		csv.Write([]string{f.URL, f.Class, strconv.Itoa(f.Attempts),
+			strconv.Itoa(f.StatusCode), f.Error})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
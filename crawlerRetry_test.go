@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com/", Err: err}
+	}
+	tests := []struct {
+		name      string
+		err       error
+		msg       string
+		class     string
+		retryable bool
+	}{
+		{"unknown host", urlErr(&net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}), "", failDNS, false},
+		{"temporary dns error", urlErr(&net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}), "", failDNS, true},
+		{"dns timeout", urlErr(&net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}), "", failDNS, true},
+		{"timeout", urlErr(timeoutError{}), "", failTimeout, true},
+		{"deadline", urlErr(context.DeadlineExceeded), "", failTimeout, true},
+		{"connection refused", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), "", failConnect, true},
+		{"eof", urlErr(io.EOF), "", failConnect, true},
+		{"unexpected eof", urlErr(fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF)), "", failConnect, true},
+		{"tls", urlErr(errors.New("tls: handshake failure")), "", failTLS, false},
+		{"other", urlErr(errors.New("stopped after 10 redirects")), "", failOther, false},
+		{"stored no such host", nil, "dial tcp: lookup example.invalid: no such host", failDNS, false},
+		{"stored server misbehaving", nil, "lookup example.com: server misbehaving", failDNS, true},
+		{"stored timeout", nil, "net/http: request canceled (Client.Timeout exceeded while awaiting headers)", failTimeout, true},
+		{"stored certificate", nil, "x509: certificate signed by unknown authority", failTLS, false},
+		{"stored connection reset", nil, "read tcp: Connection Reset by peer", failConnect, true},
+		{"stored unreachable", nil, "connect: network is unreachable", failConnect, true},
+		{"stored eof text", nil, "Get http://example.com/eof-page: bad request", failOther, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, retryable := classifyError(tt.err, tt.msg)
+			if class != tt.class || retryable != tt.retryable {
+				t.Errorf("classifyError() = %s, %t, want %s, %t", class, retryable, tt.class, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestClassifyFailureStatus(t *testing.T) {
+	tests := []struct {
+		code      int
+		class     string
+		retryable bool
+	}{
+		{200, "", false},
+		{302, "", false},
+		{404, "", false},
+		{429, failThrottle, true},
+		{500, failServer, true},
+		{501, failServer, false},
+		{503, failServer, true},
+		{505, failServer, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.code), func(t *testing.T) {
+			page := &crawlbase.Page{Response: &crawlbase.PageResponse{StatusCode: tt.code}}
+			class, retryable := classifyFailure(page, nil)
+			if class != tt.class || retryable != tt.retryable {
+				t.Errorf("classifyFailure() = %q, %t, want %q, %t", class, retryable, tt.class, tt.retryable)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"missing", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"negative", "-5", 0},
+		{"capped", "999999999", maxBackoff},
+		{"overflow", "99999999999999999999", maxBackoff},
+		{"date in the past", "Wed, 21 Oct 2015 07:28:00 GMT", 0},
+		{"date far ahead", time.Now().AddDate(10, 0, 0).UTC().Format(http.TimeFormat), maxBackoff},
+		{"invalid", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("Retry-After", tt.value)
+			}
+			if got := retryAfter(header); got != tt.want {
+				t.Errorf("retryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
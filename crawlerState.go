@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/fatih/color"
+)
+
+// crawlState is the checkpointed frontier of a crawl. It is written to
+// StorageFolder/crawlstate.json and restored with -resume.
+//
+// While fetching, the crawl loop holds mu except while it waits for a
+// response or sleeps, so the checkpoint ticker can save a consistent
+// state even if a request hangs.
+type crawlState struct {
+	StartURL  string
+	PageCount uint64
+	SavedAt   int64
+	Links     map[string]bool  // url -> crawled
+	Depths    map[string]int   // url -> link depth from the start url(s)
+	Retries   map[string]int   // url -> failed attempts
+	Backoff   map[string]int64 // url -> next attempt (unix nano)
+	Failures  map[string]*crawlFailure
+	Originals map[string][]string // canonical url -> original urls
+
+	path     string
+	interval time.Duration
+	mu       sync.Mutex
+	stop     chan struct{} // closed on SIGINT/SIGTERM
+	done     chan struct{} // closed when fetching ends
+	ticker   sync.WaitGroup
+	fetching bool // false while stored pages are loaded
+}
+
+func newCrawlState(path string, interval time.Duration) *crawlState {
+	s := &crawlState{}
+	s.path = path
+	s.interval = interval
+	s.stop = make(chan struct{})
+	s.Links = map[string]bool{}
+	s.Depths = map[string]int{}
+	s.Retries = map[string]int{}
+	s.Backoff = map[string]int64{}
+	s.Failures = map[string]*crawlFailure{}
+	s.Originals = map[string][]string{}
+	return s
+}
+
+// Load reads the state file. Returns false if there is no state to resume.
+func (s *crawlState) Load() (bool, error) {
+	if s.path == "" {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return false, err
+	}
+	// older or hand written files may miss some maps
+	if s.Links == nil {
+		s.Links = map[string]bool{}
+	}
+	if s.Depths == nil {
+		s.Depths = map[string]int{}
+	}
+	if s.Retries == nil {
+		s.Retries = map[string]int{}
+	}
+	if s.Backoff == nil {
+		s.Backoff = map[string]int64{}
+	}
+	if s.Failures == nil {
+		s.Failures = map[string]*crawlFailure{}
+	}
+	if s.Originals == nil {
+		s.Originals = map[string][]string{}
+	}
+	return true, nil
+}
+
+// Restore puts the loaded frontier back into the crawler.
+func (s *crawlState) Restore(cw *crawlbase.Crawler, settings *crawlSettings) {
+	cw.Links = s.Links
+	cw.PageCount = s.PageCount
+	if settings.Normalizer != nil {
+		for canonical, originals := range s.Originals {
+			for _, original := range originals {
+				settings.Normalizer.addOriginal(canonical, original)
+			}
+		}
+	}
+
+	open := 0
+	for _, crawled := range s.Links {
+		if !crawled {
+			open++
+		}
+	}
+	log.Println("resuming crawl,", s.PageCount, "page(s) crawled,", open, "link(s) left")
+}
+
+// Depth returns the link depth of url, urls without a known parent like
+// the start url are at depth 0.
+func (s *crawlState) Depth(url string) int {
+	return s.Depths[url]
+}
+
+// AddLinks records the depth of links found on parent, the lowest depth
+// of a link wins.
+func (s *crawlState) AddLinks(parent string, links []string) {
+	if _, ok := s.Depths[parent]; !ok {
+		s.Depths[parent] = 0
+	}
+	depth := s.Depths[parent] + 1
+	for _, link := range links {
+		if d, ok := s.Depths[link]; !ok || depth < d {
+			s.Depths[link] = depth
+		}
+	}
+}
+
+// Save writes the current frontier to the state file. While fetching it
+// must be called with mu held.
+func (s *crawlState) Save(cw *crawlbase.Crawler, settings *crawlSettings) {
+	if s.path == "" {
+		return
+	}
+
+	s.Links = cw.Links
+	s.PageCount = cw.PageCount
+	s.SavedAt = time.Now().Unix()
+	if settings.URL != nil {
+		s.StartURL = settings.URL.String()
+	}
+	if settings.Normalizer != nil {
+		s.Originals = settings.Normalizer.Originals
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		logError(err)
+		return
+	}
+	// write to a temp file first, an interrupted write must not
+	// destroy the last checkpoint
+	tmpPath := s.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0666)
+	if err != nil {
+		logError(err)
+		return
+	}
+	logError(os.Rename(tmpPath, s.path))
+}
+
+// StartFetching is called before the crawl loop starts. It takes the lock
+// for the crawl loop and saves the state every interval in the background.
+func (s *crawlState) StartFetching(cw *crawlbase.Crawler, settings *crawlSettings) {
+	base := cw.Client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	cw.Client.Transport = &unlockedTransport{Base: base, State: s}
+
+	s.mu.Lock()
+	s.fetching = true
+	s.done = make(chan struct{})
+	if s.interval <= 0 {
+		return
+	}
+	s.ticker.Add(1)
+	go func() {
+		defer s.ticker.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				s.mu.Lock()
+				if s.fetching {
+					s.Save(cw, settings)
+					logVerbose(1, "crawl state saved to", s.path)
+				}
+				s.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// StopFetching releases the lock of the crawl loop and stops the
+// checkpoint ticker.
+func (s *crawlState) StopFetching() {
+	s.fetching = false
+	close(s.done)
+	s.mu.Unlock()
+	s.ticker.Wait()
+}
+
+// Fetching is false while stored pages are loaded.
+func (s *crawlState) Fetching() bool {
+	return s.fetching
+}
+
+// Sleep waits d without holding the lock. Returns false if the crawl was
+// interrupted meanwhile.
+func (s *crawlState) Sleep(d time.Duration) bool {
+	if d <= 0 {
+		return !s.Interrupted()
+	}
+	if s.fetching {
+		s.mu.Unlock()
+		defer s.mu.Lock()
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// unlockedTransport releases the state lock while a request is sent and
+// its body is read.
+type unlockedTransport struct {
+	Base  http.RoundTripper
+	State *crawlState
+}
+
+func (t *unlockedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.State.fetching {
+		return t.Base.RoundTrip(req)
+	}
+	t.State.mu.Unlock()
+	res, err := t.Base.RoundTrip(req)
+	t.State.mu.Lock()
+	if res != nil && res.Body != nil {
+		res.Body = &unlockedBody{res.Body, t.State}
+	}
+	return res, err
+}
+
+type unlockedBody struct {
+	io.ReadCloser
+	State *crawlState
+}
+
+func (b *unlockedBody) Read(p []byte) (int, error) {
+	b.State.mu.Unlock()
+	defer b.State.mu.Lock()
+	return b.ReadCloser.Read(p)
+}
+
+// WatchSignals stops the crawl on SIGINT/SIGTERM. The crawler finishes the
+// current request, saves its state and exits. A second signal exits
+// immediately.
+func (s *crawlState) WatchSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(s.stop)
+		color.Yellow("stopping crawl after current request, press CTRL+C again to exit immediately")
+		<-signals
+		os.Exit(1)
+	}()
+}
+
+func (s *crawlState) Interrupted() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -10,7 +10,7 @@ func main() {
 	if len(args) == 1 {
 		fmt.Println("NCrawler V0.1.3")
 		fmt.Println("missing tool command")
-		fmt.Println("dns, crawler, report, portscan, wordlist, curl, httpscan, fuzzer, httpserver, bucketscan")
+		fmt.Println("dns, crawler, report, portscan, wordlist, curl, httpscan, fuzzer, httpserver, bucketscan, secrets, query")
 		return
 	}
 	if args[1] == "dns" {
@@ -33,6 +33,10 @@ func main() {
 		mainWordList()
 	} else if args[1] == "bucketscan" {
 		mainBucketScan()
+	} else if args[1] == "secrets" {
+		mainSecrets()
+	} else if args[1] == "query" {
+		mainQuery()
 	} else {
 		fmt.Println("tool " + args[1] + " not found")
 	}
@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"path"
+	"time"
 
 	"github.com/BlackEspresso/crawlbase"
 	"github.com/fatih/color"
@@ -17,12 +19,21 @@ type crawlSettings struct {
 	FileStoreURL    string
 	WaitTime        int
 	MaxPages        int
+	MaxDepth        int
 	StorageFolder   string
 	URLRegEx        string
 	FollowLinks     []string
 	DontFollowLinks []string
 	NoNewLinks      bool
 	LoadResources   bool
+	Normalizer      *urlNormalizer
+	State           *crawlState
+	Throttle        *hostThrottle
+	MaxRetries      int
+	Backoff         time.Duration
+	Extractors      []string
+	ExtractorStats  map[string]*extractorStat
+	Identity        *crawlIdentity
 }
 
 /* usage examples:
@@ -33,6 +44,9 @@ saves files to ./storage
 ncrawler.exe -report test.csv -storage ./storage
 => just generates reports from prev. crawls files stored in ./storage. All urls.
 
+ncrawler.exe crawler -storage-path ./storage -resume
+=> continues a stopped crawl with the frontier saved in ./storage/crawlstate.json
+
 */
 
 var debugMode = false
@@ -44,6 +58,7 @@ func mainCrawler() {
 	//urlRegEx := flag.String("regex", "", "only crawl links using this regex")
 	waitFlag := fs.Int("wait", 500, "delay, in milliseconds")
 	maxPagesFlag := fs.Int("max-pages", -1, "max pages to crawl, -1 for infinite")
+	maxDepth := fs.Int("max-depth", -1, "max link depth from the start url(s), -1 for infinite")
 	//fs.String("storageType", "file", "type of storage. (http,file,ftp)")
 	storagePathFlag := fs.String("storage-path", "",
 		"folder to store crawled files")
@@ -53,6 +68,21 @@ func mainCrawler() {
 		"dont crawl hrefs links. Use with url-list for example.")
 	scopeToDomain := fs.Bool("scoped-to-domain", true, "scope the crawler to the domain")
 	loadResource := fs.Bool("load-resources", false, "load ressources like images,css,js...")
+	normalize := fs.Bool("normalize", false, "canonicalize urls before adding them (sort params, drop session params, ...)")
+	normalizeConfig := fs.String("normalize-config", "./config/normalize.json", "path to url normalization rules")
+	resume := fs.Bool("resume", false, "resume the crawl from the state saved in the storage folder")
+	checkpoint := fs.Int("checkpoint", 60, "save the crawl state every n seconds, 0 to disable")
+	maxRetries := fs.Int("max-retries", 3, "retries for timeouts, connection errors, 5xx and 429 responses")
+	backoff := fs.Int("backoff", 1000, "first retry delay in milliseconds, doubled on every retry")
+	extractors := fs.String("extractors", "anchors",
+		"link extractors, comma separated or all: anchors, srcset, meta-refresh, link-header, "+
+			"content-location, css, forms, iframes, data-attributes, redirects")
+
+	uaList := fs.String("ua-list", "", "path to a list of user agents, rotated per request")
+	tagHeader := fs.String("tag-header", "", "header to tag every request with a unique value, e.g. X-Scan-Id")
+
+	headers := stringslice{}
+	fs.Var(&headers, "H", "header, e.g. -H \"X-Api-Key: 123\"")
 
 	var followLinks, followLinksNot arrayFlags
 	fs.Var(&followLinks, "links-follow", "some test flag")
@@ -62,7 +92,7 @@ func mainCrawler() {
 
 	fs.Parse(os.Args[2:])
 
-	if *urlFlag == "" && *urlList == "" {
+	if *urlFlag == "" && *urlList == "" && !*resume {
 		color.Red("no url or url list provided.")
 	}
 
@@ -71,43 +101,107 @@ func mainCrawler() {
 	settings := crawlSettings{}
 	settings.WaitTime = *waitFlag
 	settings.MaxPages = *maxPagesFlag
+	settings.MaxDepth = *maxDepth
 	settings.StorageFolder = *storagePathFlag
 	settings.FollowLinks = followLinks
 	settings.DontFollowLinks = followLinksNot
 	settings.NoNewLinks = *noNewLinks
 	settings.LoadResources = *loadResource
+	settings.MaxRetries = *maxRetries
+	settings.Backoff = time.Duration(*backoff) * time.Millisecond
+	settings.Throttle = newHostThrottle()
+	settings.ExtractorStats = map[string]*extractorStat{}
+	settings.Identity = newCrawlIdentity()
+	settings.Identity.TagHeader = *tagHeader
+
+	var err error
+	settings.Extractors, err = parseExtractorNames(*extractors)
+	if err != nil {
+		color.Red(err.Error())
+		return
+	}
+
+	if *normalize {
+		rules, err := loadNormalizeRules(*normalizeConfig)
+		checkError(err)
+		settings.Normalizer = newURLNormalizer(*rules)
+		if settings.StorageFolder != "" {
+			err = settings.Normalizer.LoadMapFile(path.Join(settings.StorageFolder, "urlmap.csv"))
+			checkError(err)
+		}
+	}
 
 	cw := crawlbase.NewCrawler()
 	cw.WaitBetweenRequests = settings.WaitTime
 	cw.StorageFolder = settings.StorageFolder
 	cw.ScopeToDomain = *scopeToDomain
+	hostHeader := setCrawlerHeaders(cw, headers)
+
+	if *uaList != "" {
+		settings.Identity.UserAgents, err = crawlbase.ReadWordlist(*uaList)
+		checkError(err)
+	}
 	cw.BeforeCrawlFn = func(url string) (string, error) {
 		return BeforeCrawlFn(&settings, cw, url)
 	}
 	cw.AfterCrawlFn = func(p *crawlbase.Page, err error) ([]string, error) {
-		return AfterCrawlFn(&settings, p, err)
+		return AfterCrawlFn(&settings, cw, p, err)
 	}
 
 	if doesExists, _ := exists(settings.StorageFolder); !doesExists && settings.StorageFolder != "" {
 		os.Mkdir(settings.StorageFolder, 0777)
 	}
 
-	pagesLoaded, err := cw.LoadPages(settings.StorageFolder)
-	checkError(err)
+	statePath := ""
+	if settings.StorageFolder != "" {
+		statePath = path.Join(settings.StorageFolder, "crawlstate.json")
+	}
+	settings.State = newCrawlState(statePath, time.Duration(*checkpoint)*time.Second)
+
+	resumed := false
+	if *resume {
+		if statePath == "" {
+			color.Red("resume needs a storage path.")
+			return
+		}
+		resumed, err = settings.State.Load()
+		checkError(err)
+		if resumed {
+			settings.State.Restore(cw, &settings)
+		} else {
+			log.Println("no crawl state found in", settings.StorageFolder, ", starting new crawl")
+		}
+	}
 
-	log.Println("Loaded pages: ", pagesLoaded)
+	if !resumed {
+		pagesLoaded, err := cw.LoadPages(settings.StorageFolder)
+		checkError(err)
+
+		log.Println("Loaded pages: ", pagesLoaded)
+	}
 
 	var baseURL *url.URL
 
-	if *urlFlag != "" {
+	startURL := *urlFlag
+	if startURL == "" && resumed {
+		startURL = settings.State.StartURL
+	}
+
+	if startURL != "" {
 		// parse url & remove all out of scope urls
-		baseURL, err = url.Parse(*urlFlag)
+		if settings.Normalizer != nil {
+			startURL = settings.Normalizer.Add(startURL)
+		}
+		baseURL, err = url.Parse(startURL)
 		checkError(err)
+		if hostHeader != nil {
+			hostHeader.AddTarget(baseURL.Host)
+		}
 		cw.RemoveLinksNotSameHost(baseURL)
 		settings.URL = baseURL
 	}
 
-	if *noNewLinks {
+	if *noNewLinks && !resumed {
 		// set all to crawled
 		for k := range cw.Links {
 			cw.Links[k] = true
@@ -129,36 +223,144 @@ func mainCrawler() {
 				checkError(err)
 				if newURL.IsAbs() {
 					newURLs = append(newURLs, l)
+					if hostHeader != nil {
+						hostHeader.AddTarget(newURL.Host)
+					}
 				}
 			}
 		}
 
+		if settings.Normalizer != nil {
+			newURLs = settings.Normalizer.AddAll(newURLs)
+		}
 		cw.AddAllLinks(newURLs)
 		if baseURL != nil {
 			cw.RemoveLinksNotSameHost(baseURL)
 		}
 	}
 
-	if baseURL != nil {
-		cw.FetchSites(baseURL)
-	} else if *urlList != "" {
-		cw.FetchSites(nil)
+	settings.State.WatchSignals()
+	settings.State.StartFetching(cw, &settings)
+
+	if baseURL != nil || *urlList != "" || resumed {
+		err = fetchSites(&settings, cw, baseURL)
 	}
+	settings.State.StopFetching()
+	if err != nil {
+		log.Println("crawl stopped: ", err)
+	}
+
+	settings.State.Save(cw, &settings)
+	if settings.Normalizer != nil {
+		logError(settings.Normalizer.Close())
+	}
+	writeFailureSummary(&settings)
+	writeExtractorStats(&settings)
 }
 
-func BeforeCrawlFn(settings *crawlSettings, cw *crawlbase.Crawler, url string) (string, error) {
+// fetchSites is the crawl loop of crawlbase.FetchSites, except that an
+// attempt which will be retried is neither stored nor counted for
+// -max-pages. Without startURL the links in cw.Links are crawled.
+func fetchSites(settings *crawlSettings, cw *crawlbase.Crawler, startURL *url.URL) error {
+	startFirst := false
+	if startURL != nil {
+		cw.AddAllLinks([]string{startURL.String()})
+		if !cw.IsCrawled(startURL.String()) {
+			startFirst = true
+		} else {
+			log.Println("start url already crawled, skipping: ", startURL.String())
+		}
+	}
+
+	for {
+		link, found := "", false
+		if startFirst {
+			link, found = startURL.String(), true
+			startFirst = false
+		} else {
+			link, found = cw.GetNextLink()
+		}
+		if !found {
+			log.Println("no more links. crawled ", cw.PageCount, "page(s).")
+			return nil
+		}
+
+		link, err := BeforeCrawlFn(settings, cw, link)
+		if err != nil {
+			return err
+		}
+		cw.Links[link] = true
+
+		pURL, err := url.Parse(link)
+		if err != nil {
+			log.Println("error while parsing url: " + err.Error())
+			continue
+		}
+		if !cw.IsValidScheme(pURL) {
+			log.Println("scheme invalid, skipping url:" + link)
+			continue
+		}
+
+		page, err := cw.GetPage(link, "GET")
+		if page == nil {
+			logError(err)
+			continue
+		}
+		log.Println("fetched site: "+link, page.Response.StatusCode, len(page.ResponseBody))
+		settings.Identity.Sent()
+		retried := handleFailure(settings, cw, page, err)
+
+		links, err := AfterCrawlFn(settings, cw, page, err)
+		if err != nil {
+			log.Println("after page crawl error: ", err)
+		}
+		if !retried {
+			cw.SavePage(page)
+			cw.PageCount++
+		}
+
+		if startURL != nil && cw.ScopeToDomain {
+			cw.AddLinksMatchingDomain(links, startURL)
+		} else {
+			cw.AddAllLinks(links)
+		}
+		settings.State.Sleep(time.Duration(cw.WaitBetweenRequests) * time.Millisecond)
+	}
+}
+
+func BeforeCrawlFn(settings *crawlSettings, cw *crawlbase.Crawler, link string) (string, error) {
 	if settings.MaxPages >= 0 && cw.PageCount >= uint64(settings.MaxPages) {
 		log.Println("crawled ", cw.PageCount, "link(s), max pages reached.")
 		return "", errors.New("max pages reached")
 	}
-	return url, nil
+	link = nextDueLink(settings.State, cw, link)
+
+	if settings.Normalizer != nil {
+		canonical := settings.Normalizer.Add(link)
+		if canonical != link {
+			// the crawler marks only the returned link as crawled,
+			// keep the original link from being picked again
+			cw.Links[link] = true
+		}
+		link = canonical
+	}
+
+	if settings.State.Fetching() {
+		if pURL, err := url.Parse(link); err == nil {
+			settings.Throttle.Wait(pURL.Host, settings.State.Sleep)
+		}
+		settings.Identity.Apply(cw.Header)
+	}
+	if settings.State.Interrupted() {
+		return "", errors.New("interrupted")
+	}
+	return link, nil
 }
 
-func AfterCrawlFn(settings *crawlSettings, page *crawlbase.Page, err error) ([]string, error) {
+func AfterCrawlFn(settings *crawlSettings, cw *crawlbase.Crawler, page *crawlbase.Page, err error) ([]string, error) {
 	if page == nil {
 		return nil, err
 	}
-
 	var crawlLinks []string
 
 	isRedirect := page.Response.StatusCode >= 300 && page.Response.StatusCode < 308
@@ -169,23 +371,20 @@ func AfterCrawlFn(settings *crawlSettings, page *crawlbase.Page, err error) ([]s
 				crawlLinks = append(crawlLinks, val[0])
 			}
 		}
+		crawlLinks = normalizeLinks(settings, page, crawlLinks)
 		return crawlLinks, nil
 	}
 
 	hasFollowFilter := len(settings.FollowLinks) > 0
 	hasDontFollowFilter := len(settings.DontFollowLinks) > 0
 
-	if hasFollowFilter || hasDontFollowFilter {
-		for _, link := range page.RespInfo.Hrefs {
-			matchFollow := hasFollowFilter && containsAllText(settings.FollowLinks, link)
-			matchDontFollow := hasDontFollowFilter && containsAnyText(settings.DontFollowLinks, link)
+	for _, link := range extractLinks(settings, cw, page) {
+		matchFollow := !hasFollowFilter || containsAllText(settings.FollowLinks, link)
+		matchDontFollow := hasDontFollowFilter && containsAnyText(settings.DontFollowLinks, link)
 
-			if matchFollow && !matchDontFollow {
-				crawlLinks = append(crawlLinks, link)
-			}
+		if matchFollow && !matchDontFollow {
+			crawlLinks = append(crawlLinks, link)
 		}
-	} else {
-		copy(crawlLinks, page.RespInfo.Hrefs)
 	}
 
 	if settings.LoadResources {
@@ -194,9 +393,29 @@ func AfterCrawlFn(settings *crawlSettings, page *crawlbase.Page, err error) ([]s
 		}
 	}
 
+	crawlLinks = normalizeLinks(settings, page, crawlLinks)
 	return crawlLinks, err
 }
 
+// normalizeLinks canonicalizes links, records their depth and drops links
+// deeper than -max-depth.
+func normalizeLinks(settings *crawlSettings, page *crawlbase.Page, links []string) []string {
+	if settings.Normalizer != nil {
+		links = settings.Normalizer.AddAll(links)
+	}
+	settings.State.AddLinks(page.URL, links)
+	if settings.MaxDepth < 0 {
+		return links
+	}
+	kept := []string{}
+	for _, link := range links {
+		if settings.State.Depth(link) <= settings.MaxDepth {
+			kept = append(kept, link)
+		}
+	}
+	return kept
+}
+
 func containsAnyText(testStrings []string, text string) bool {
 	for _, tester := range testStrings {
 		if strings.Contains(text, tester) {
@@ -50,15 +50,7 @@ func mainHttpCurl() {
 		req.URL.Scheme = *schemeFlag
 	}
 
-	// apply headers
-	for _, header := range headers {
-		kv := strings.SplitN(header, ":", 2)
-		if len(kv) > 1 {
-			req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
-		} else {
-			req.Header.Set(strings.TrimSpace(kv[0]), "")
-		}
-	}
+	applyHeaders(req.Header, headers)
 
 	resp, err := http.DefaultClient.Do(req)
 	checkError(err)
@@ -70,6 +62,18 @@ func mainHttpCurl() {
 
 }
 
+// applyHeaders sets headers given as "Name: value" on h.
+func applyHeaders(h http.Header, headers []string) {
+	for _, header := range headers {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) > 1 {
+			h.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		} else {
+			h.Set(strings.TrimSpace(kv[0]), "")
+		}
+	}
+}
+
 func writeHttpResponseToFile(fileName string, resp *http.Response) {
 	f, err := os.Create(fileName)
 	checkError(err)
@@ -78,6 +82,18 @@ func writeHttpResponseToFile(fileName string, resp *http.Response) {
 	resp.Write(f)
 }
 
+// writeHttpRequestToFile writes req as raw http request with an absolute
+// url, so readHttpRequest keeps the scheme.
+func writeHttpRequestToFile(fileName string, req *http.Request) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return req.WriteProxy(f)
+}
+
 func readHttpRequest(fileName string) (*http.Request, error) {
 	f, err := os.Open(fileName)
 	checkError(err)
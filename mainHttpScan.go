@@ -89,7 +89,7 @@ func mainHTTPScan() {
 
 	req := getRequest(settings)
 	scan.BaseRequest = req
-	baseResult := doRequest(settings, scan.BaseRequest, &attackVector{}, "BaseRequest")
+	baseResult := doRequest(settings, copyRequest(scan.BaseRequest), &attackVector{}, "BaseRequest")
 
 	data, err := ioutil.ReadFile(settings.VectorFile)
 	checkError(err)
@@ -129,6 +129,25 @@ func scanURL(settings *appScannerSettings, scan *appScan) []*scanResult {
 		}
 	}
 
+	// form encoded bodies, e.g. POST forms of 'report -param-requests'
+	if isFormBody(scan.BaseRequest) {
+		body := readBody(scan.BaseRequest)
+		bValues, err := url.ParseQuery(string(body))
+		if err != nil {
+			logError(err)
+		}
+		for key := range bValues {
+			for _, vec := range scan.Vectors {
+				values, _ := url.ParseQuery(string(body))
+				values.Set(key, vec.Vector)
+				req := copyRequest(scan.BaseRequest)
+				setBody(req, []byte(values.Encode()))
+				result := doRequest(settings, req, vec, "body "+key)
+				results = append(results, result)
+			}
+		}
+	}
+
 	if settings.ScanHTTPHeaders {
 		for key := range scan.BaseRequest.Header {
 			for _, vec := range scan.Vectors {
@@ -203,9 +222,36 @@ func doRequest(settings *appScannerSettings, req *http.Request,
 	return result
 }
 
+func isFormBody(req *http.Request) bool {
+	mime := strings.ToLower(req.Header.Get("Content-Type"))
+	return req.Body != nil && strings.HasPrefix(mime, "application/x-www-form-urlencoded")
+}
+
+// readBody reads the body of req and puts it back, so req can be sent or
+// copied again.
+func readBody(req *http.Request) []byte {
+	if req.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	logError(err)
+	req.Body.Close()
+	setBody(req, body)
+	return body
+}
+
+func setBody(req *http.Request, body []byte) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+}
+
 func copyRequest(req *http.Request) *http.Request {
+	body := readBody(req)
 	buffer := new(bytes.Buffer)
 	req.Write(buffer)
+	if body != nil {
+		setBody(req, body)
+	}
 	newreq, err := http.ReadRequest(bufio.NewReader(buffer))
 	checkError(err)
 	newreq.URL.Host = req.URL.Host
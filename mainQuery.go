@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/fatih/color"
+)
+
+var regQueryExpr = regexp.MustCompile(`^(header:[\w-]+|[a-z]+)(>=|<=|!=|!~|=|~|>|<)(.*)$`)
+
+// queryFilter is a parsed expression like status>=500 or body~/password/.
+type queryFilter struct {
+	Field string
+	Op    string
+	Value string
+	regex *regexp.Regexp
+}
+
+type queryMatch struct {
+	URL        string `json:"url"`
+	Method     string `json:"method"`
+	StatusCode int    `json:"status"`
+	Mime       string `json:"mime"`
+	Duration   int    `json:"durationMs"`
+	Size       int    `json:"size"`
+	File       string `json:"file"`
+	page       *crawlbase.Page
+}
+
+type settingsQuery struct {
+	StoragePath    string
+	Output         string
+	RequestsFolder string
+	Filters        []*queryFilter
+}
+
+func mainQuery() {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+
+	storagePath := fs.String("storage-path", "./storage", "folder with crawled files from 'crawler'")
+	output := fs.String("output", "urls", "output format: urls, json or requests")
+	requestsFolder := fs.String("requests-folder", "./requests", "folder for raw request files of '-output requests'")
+
+	fs.Usage = func() {
+		fmt.Println("usage: query [flags] expression...")
+		fmt.Println("expressions are combined with and, e.g. status>=500 mime=application/json")
+		fmt.Println("fields: url, status, mime, body, duration, size, header:<name>")
+		fmt.Println("operators: = != > >= < <= ~ (regex) !~ (not regex), regex as ~/re/ or ~re")
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[2:])
+
+	settings := settingsQuery{
+		StoragePath:    *storagePath,
+		Output:         *output,
+		RequestsFolder: *requestsFolder,
+	}
+	for _, expr := range fs.Args() {
+		filter, err := parseQueryFilter(expr)
+		checkError(err)
+		settings.Filters = append(settings.Filters, filter)
+	}
+
+	matches := queryStorage(&settings)
+
+	switch settings.Output {
+	case "urls":
+		for _, m := range matches {
+			fmt.Println(m.URL)
+		}
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		checkError(enc.Encode(matches))
+	case "requests":
+		writeQueryRequests(settings.RequestsFolder, matches)
+		color.Green("%d request(s) written to %s", len(matches), settings.RequestsFolder)
+	default:
+		color.Red("unknown output %s", settings.Output)
+	}
+}
+
+func parseQueryFilter(expr string) (*queryFilter, error) {
+	m := regQueryExpr.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid expression %s", expr)
+	}
+	filter := &queryFilter{Field: strings.ToLower(m[1]), Op: m[2], Value: m[3]}
+
+	switch filter.Field {
+	case "url", "status", "mime", "body", "duration", "size":
+	default:
+		if !strings.HasPrefix(filter.Field, "header:") {
+			return nil, fmt.Errorf("unknown field %s in %s", filter.Field, expr)
+		}
+	}
+
+	if filter.Op == "~" || filter.Op == "!~" {
+		re := filter.Value
+		if len(re) >= 2 && strings.HasPrefix(re, "/") && strings.HasSuffix(re, "/") {
+			re = re[1 : len(re)-1]
+		}
+		var err error
+		filter.regex, err = regexp.Compile(re)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// fieldValue returns the field as text, numeric fields are compared as
+// numbers.
+func (f *queryFilter) fieldValue(page *crawlbase.Page) string {
+	switch f.Field {
+	case "url":
+		return page.URL
+	case "body":
+		return string(page.ResponseBody)
+	case "size":
+		return strconv.Itoa(len(page.ResponseBody))
+	case "duration":
+		return strconv.Itoa(page.RespDuration)
+	}
+	if page.Response == nil {
+		if f.Field == "status" {
+			return "0"
+		}
+		return ""
+	}
+	switch f.Field {
+	case "status":
+		return strconv.Itoa(page.Response.StatusCode)
+	case "mime":
+		return crawlbase.GetContentMime(page.Response.Header)
+	}
+	name := strings.TrimPrefix(f.Field, "header:")
+	return strings.Join(page.Response.Header.Values(name), "\n")
+}
+
+func (f *queryFilter) Match(page *crawlbase.Page) bool {
+	value := f.fieldValue(page)
+	switch f.Op {
+	case "~":
+		return f.regex.MatchString(value)
+	case "!~":
+		return !f.regex.MatchString(value)
+	case "=":
+		return strings.EqualFold(value, f.Value)
+	case "!=":
+		return !strings.EqualFold(value, f.Value)
+	}
+
+	a, errA := strconv.ParseFloat(value, 64)
+	b, errB := strconv.ParseFloat(f.Value, 64)
+	if errA != nil || errB != nil {
+		return false
+	}
+	switch f.Op {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	return false
+}
+
+func queryStorage(settings *settingsQuery) []*queryMatch {
+	files, err := crawlbase.GetPageInfoFiles(settings.StoragePath)
+	checkError(err)
+
+	matches := []*queryMatch{}
+	for _, file := range files {
+		page, err := crawlbase.LoadPage(file, true)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		matched := true
+		for _, f := range settings.Filters {
+			if !f.Match(page) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		m := &queryMatch{
+			URL:      page.URL,
+			Method:   storedRequestMethod(file),
+			Duration: page.RespDuration,
+			Size:     len(page.ResponseBody),
+			File:     file,
+		}
+		if page.Response != nil {
+			m.StatusCode = page.Response.StatusCode
+			m.Mime = crawlbase.GetContentMime(page.Response.Header)
+		}
+		// the body is not needed anymore
+		page.ResponseBody = nil
+		page.RequestBody = storedRequestBody(file)
+		m.page = page
+		matches = append(matches, m)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].URL < matches[j].URL
+	})
+	return matches
+}
+
+// storedRequestMethod returns the method of the request stored in an httpi
+// file. crawlbase.PageRequest has no method, pages without one were
+// fetched with GET.
+func storedRequestMethod(file string) string {
+	stored := struct {
+		Request *struct{ Method string }
+	}{}
+	data, err := ioutil.ReadFile(file)
+	if err == nil && json.Unmarshal(data, &stored) == nil &&
+		stored.Request != nil && stored.Request.Method != "" {
+		return strings.ToUpper(stored.Request.Method)
+	}
+	return "GET"
+}
+
+// storedRequestBody returns the request body stored next to an httpi file
+// as .reqbin, like the response body in .respbin. nil if there is none.
+func storedRequestBody(file string) []byte {
+	body, err := ioutil.ReadFile(strings.Replace(file, ".httpi", ".reqbin", 1))
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// writeQueryRequests writes the stored request of each match as raw http
+// request for 'httpscan -input' and 'httppipe -input'.
+func writeQueryRequests(folder string, matches []*queryMatch) {
+	err := os.MkdirAll(folder, 0777)
+	checkError(err)
+
+	for i, m := range matches {
+		var body io.Reader
+		if len(m.page.RequestBody) > 0 {
+			body = bytes.NewReader(m.page.RequestBody)
+		}
+		req, err := http.NewRequest(m.Method, m.URL, body)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		if m.page.Request != nil {
+			for name, values := range m.page.Request.Header {
+				req.Header[name] = values
+			}
+		}
+		fileName := strconv.Itoa(i) + "_" + m.Method + "_" + toFileName(req.URL.Host+req.URL.Path) + ".req"
+		err = writeHttpRequestToFile(path.Join(folder, fileName), req)
+		logError(err)
+	}
+}
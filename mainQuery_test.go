@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+func TestParseQueryFilter(t *testing.T) {
+	tests := []struct {
+		expr    string
+		field   string
+		op      string
+		value   string
+		wantErr bool
+	}{
+		{"status=200", "status", "=", "200", false},
+		{"status>=400", "status", ">=", "400", false},
+		{"duration>1000", "duration", ">", "1000", false},
+		{"size<=10", "size", "<=", "10", false},
+		{"mime!=text/html", "mime", "!=", "text/html", false},
+		{"url~/admin/", "url", "~", "/admin/", false},
+		{"body!~password", "body", "!~", "password", false},
+		{"header:X-Powered-By~PHP", "header:x-powered-by", "~", "PHP", false},
+		{"url=a=b", "url", "=", "a=b", false},
+		{"status=", "status", "=", "", false},
+		{"cookie=1", "", "", "", true},
+		{"status", "", "", "", true},
+		{"=200", "", "", "", true},
+		{"url~(", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := parseQueryFilter(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQueryFilter(%q) error = %v, wantErr %t", tt.expr, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if f.Field != tt.field || f.Op != tt.op || f.Value != tt.value {
+				t.Errorf("parseQueryFilter(%q) = %s %s %s, want %s %s %s",
+					tt.expr, f.Field, f.Op, f.Value, tt.field, tt.op, tt.value)
+			}
+		})
+	}
+}
+
+func TestQueryFilterMatch(t *testing.T) {
+	page := &crawlbase.Page{
+		URL:          "http://example.com/admin/login?next=/",
+		RespDuration: 1500,
+		ResponseBody: []byte("<form>password</form>"),
+		Response: &crawlbase.PageResponse{
+			StatusCode: 403,
+			Header: http.Header{
+				"Content-Type": {"text/html; charset=utf-8"},
+				"X-Powered-By": {"PHP/7.4"},
+			},
+		},
+	}
+	failed := &crawlbase.Page{URL: "http://example.com/down", Error: "connection refused"}
+
+	tests := []struct {
+		expr string
+		page *crawlbase.Page
+		want bool
+	}{
+		{"status=403", page, true},
+		{"status!=403", page, false},
+		{"status>=400", page, true},
+		{"status<400", page, false},
+		{"duration>1000", page, true},
+		{"duration<=1000", page, false},
+		{"size=21", page, true},
+		{"mime=TEXT/HTML", page, true},
+		{"url~/admin/", page, true},
+		{"url~^https:", page, false},
+		{"url!~logout", page, true},
+		{"body~pass(word)?", page, true},
+		{"header:x-powered-by~^PHP/7", page, true},
+		{"header:server~.", page, false},
+		{"status>abc", page, false},
+		{"status=0", failed, true},
+		{"mime=", failed, true},
+		{"header:server=", failed, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			f, err := parseQueryFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.Match(tt.page); got != tt.want {
+				t.Errorf("Match(%q) = %t, want %t", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
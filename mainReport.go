@@ -1,30 +1,54 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"runtime"
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/BlackEspresso/crawlbase"
 	"github.com/BlackEspresso/html2text"
 	"github.com/BlackEspresso/htmlcheck"
+	"github.com/PuerkitoBio/goquery"
 	"github.com/fatih/color"
 )
 
 type reportSettings struct {
-	ReportFile    string
-	StoragePath   string
-	ProfileFolder string
-	Profile       bool
-	WordList      bool
-	TagsFiles     string
+	ReportFile       string
+	StoragePath      string
+	ProfileFolder    string
+	Profile          bool
+	WordList         bool
+	TagsFiles        string
+	TechFile         string
+	Technologies     []*technology
+	JSVulnFile       string
+	JSLibraries      []*jsLibrary
+	NormalizeConfig  string
+	Normalizer       *urlNormalizer
+	ParamRequests    bool
+	RedirectRequests bool
+	HTMLReport       bool
+	HTMLTemplate     string
+	Format           string
+	Cache            bool
+	Workers          int
+	Graph            string
+	HTMLRules        []*htmlRule
+	MaxPerPage       int
+	MinSeverity      string
+	DiffThreshold    float64
 }
 
 type pageReport struct {
@@ -42,6 +66,19 @@ type pageReport struct {
 	QueryKeys         map[string]bool
 	Hrefs             map[string]bool
 	Forms             []crawlbase.Form
+	Technologies      []*techMatch
+	JSONKeys          map[string]bool
+	Cookies           map[string]string
+	RequestCookies    map[string]string
+	ScriptParams      map[string]bool
+	Header            http.Header
+	ContentType       string
+	LinkTexts         map[string]string
+	Artifacts         []devArtifact
+	Resources         []pageResource
+	JSONSchema        *jsonSchema
+	DocMetadata       []docMetadata
+	JSLibraries       []jsLibraryMatch
 }
 
 type wordInfo struct {
@@ -57,6 +94,23 @@ func mainReport() {
 	profiling := fs.Bool("profiling", false, "enable profiling")
 	wordlist := fs.Bool("wordlist", false, "generates a wordlist from crawled pages")
 	tagsFile := fs.String("tagsfile", "./config/tags.json", "path to tags file")
+	techFile := fs.String("techfile", "./config/technologies.json", "path to technology fingerprint rules")
+	jsVulnFile := fs.String("jsvulns", "./config/jsvulns.json", "path to javascript library vulnerability database")
+	normalizeConfig := fs.String("normalize-config", "./config/normalize.json", "url normalization rules for links of a crawl with -normalize")
+	paramRequests := fs.Bool("param-requests", false, "write raw requests per endpoint for 'httpscan -input' to reportsfolder/requests")
+	redirectRequests := fs.Bool("redirect-requests", false, "write raw requests per open redirect candidate for 'httpscan -input' to reportsfolder/openredirects")
+	htmlReport := fs.Bool("html", false, "generates a single file html report (reportsfolder/report.html)")
+	htmlTemplate := fs.String("html-template", "./template/crawlreport.tmpl", "path to html report template")
+	workers := fs.Int("workers", runtime.NumCPU(), "number of pages processed in parallel")
+	cache := fs.Bool("cache", true, "reuse processed pages of unchanged files (reportsfolder/reportcache.json)")
+	graph := fs.String("graph", "", "exports the link graph as dot, graphml or json (reportsfolder/linkgraph.*)")
+	htmlRules := fs.String("html-rules", "./config/htmlrules.json", "path to html validation rule profiles")
+	htmlProfile := fs.String("html-profile", "default", "html validation rule profile")
+	maxPerPage := fs.Int("max-per-page", 0, "max reported html validation errors per page and reason, 0 = all")
+	minSeverity := fs.String("min-severity", "info", "min severity of reported html validation errors: high, medium, low or info")
+	diff := fs.String("diff", "", "old storage to compare with, usage: report -diff old_storage new_storage (reportsfolder/diff.csv)")
+	diffThreshold := fs.Float64("diff-threshold", 0.8, "bodies with a line similarity below this are reported as changed")
+	format := fs.String("format", "csv", "additional export of all page data: csv (none), json, jsonl or sqlite")
 
 	fs.Parse(os.Args[2:])
 
@@ -67,35 +121,80 @@ func mainReport() {
 	settings.Profile = *profiling
 	settings.WordList = *wordlist
 	settings.TagsFiles = *tagsFile
+	settings.TechFile = *techFile
+	settings.JSVulnFile = *jsVulnFile
+	settings.NormalizeConfig = *normalizeConfig
+	settings.ParamRequests = *paramRequests
+	settings.RedirectRequests = *redirectRequests
+	settings.HTMLReport = *htmlReport
+	settings.HTMLTemplate = *htmlTemplate
+	settings.Format = *format
+	settings.Graph = *graph
+	settings.MaxPerPage = *maxPerPage
+	settings.MinSeverity = *minSeverity
+
+	if _, ok := severityOrder[settings.MinSeverity]; !ok {
+		color.Red("invalid min-severity %s", settings.MinSeverity)
+		return
+	}
+	if !crawlbase.ContainsString([]string{formatCSV, formatJSON, formatJSONL, formatSQLite}, settings.Format) {
+		color.Red("unknown format %s", settings.Format)
+		return
+	}
+	if settings.Graph != "" && !crawlbase.ContainsString(graphFormats, settings.Graph) {
+		color.Red("unknown graph format %s", settings.Graph)
+		return
+	}
+	var err error
+	settings.HTMLRules, err = loadHTMLRules(*htmlRules, *htmlProfile)
+	checkError(err)
+	settings.Cache = *cache
+	settings.Workers = *workers
+	if settings.Workers < 1 {
+		settings.Workers = 1
+	}
+
+	settings.DiffThreshold = *diffThreshold
 
 	if *reportFile == "" {
 		color.Red("missing report file")
 		return
 	}
 
+	if *diff != "" {
+		newStorage := settings.StoragePath
+		if fs.NArg() > 0 {
+			newStorage = fs.Arg(0)
+		}
+		genReportDiff(settings, *diff, newStorage)
+		return
+	}
+
 	generateReport(settings)
 }
 
+// filterInvalidHTMLByType returns the first max validation errors with the
+// given reason, all if max <= 0.
 func filterInvalidHTMLByType(validations []*htmlcheck.ValidationError,
 	reason htmlcheck.ErrorReason, max int) []*htmlcheck.ValidationError {
 
 	var errors []*htmlcheck.ValidationError
-	c := 0
 	for _, k := range validations {
+		if max > 0 && len(errors) >= max {
+			break
+		}
 		if k.Reason == reason {
 			errors = append(errors, k)
 		}
-
-		if c > max {
-			break
-		}
 	}
 	return errors
 }
 
-func loadPage(file string, vdtr *htmlcheck.Validator, doWordlist bool) *pageReport {
+func loadPage(file string, vdtr *htmlcheck.Validator, settings *reportSettings) (*pageReport, error) {
 	page, err := crawlbase.LoadPage(file, true)
-	checkError(err)
+	if err != nil {
+		return nil, err
+	}
 
 	pr := &pageReport{}
 	pr.RespDuration = page.RespDuration
@@ -109,6 +208,7 @@ func loadPage(file string, vdtr *htmlcheck.Validator, doWordlist bool) *pageRepo
 	h2tSettings := html2text.NewSettings()
 	h2tSettings.IncludeLinkUrls = false
 
+	doWordlist := settings.WordList
 	if doWordlist {
 		rawText := crawlbase.GetUrlsFromText(page.ResponseBody, 100)
 		pr.TextUrls = bytesToStrings(rawText)
@@ -118,8 +218,10 @@ func loadPage(file string, vdtr *htmlcheck.Validator, doWordlist bool) *pageRepo
 
 	if page.Response != nil {
 		pr.StatusCode = page.Response.StatusCode
+		pr.Header = page.Response.Header
 
 		mime := crawlbase.GetContentMime(page.Response.Header)
+		pr.ContentType = mime
 		if mime == "text/html" {
 			body := string(page.ResponseBody)
 			vErros := vdtr.ValidateHtmlString(body)
@@ -135,6 +237,13 @@ func loadPage(file string, vdtr *htmlcheck.Validator, doWordlist bool) *pageRepo
 				pr.Words = bytesToStrings(rawWords)
 			}
 		}
+		if strings.Contains(mime, "json") {
+			pr.JSONKeys = getJSONKeys(page.ResponseBody)
+			pr.JSONSchema = inferJSONSchema(page.ResponseBody)
+		}
+		if mime == "text/html" || strings.Contains(mime, "javascript") {
+			pr.ScriptParams = getScriptParams(page.ResponseBody, mime)
+		}
 		/* else {
 			rawWords := crawlbase.GetWordListFromText(page.ResponseBody, 2000)
 			pr.Words = bytesToStrings(rawWords)
@@ -143,8 +252,7 @@ func loadPage(file string, vdtr *htmlcheck.Validator, doWordlist bool) *pageRepo
 
 	pURL, err := url.Parse(page.URL)
 	if err != nil {
-		color.Red("url invalid, skipping", page.URL)
-		return nil
+		return nil, err
 	}
 
 	if page.Response != nil {
@@ -152,6 +260,19 @@ func loadPage(file string, vdtr *htmlcheck.Validator, doWordlist bool) *pageRepo
 		if isRedirect {
 			pr.Location = location
 		}
+		// parsed once for all html extractors, nil if not html
+		var doc *goquery.Document
+		if pr.ContentType == "text/html" {
+			doc, err = goquery.NewDocumentFromReader(bytes.NewReader(page.ResponseBody))
+			if err != nil {
+				logError(err)
+			}
+			pr.LinkTexts = getLinkTexts(doc, pURL)
+			pr.Resources = getPageResources(page, doc, pURL)
+		} else {
+			pr.DocMetadata = getDocumentMetadata(page.ResponseBody)
+		}
+		pr.Artifacts = getDevArtifacts(page.ResponseBody, doc, pr.ContentType, page.Response.Header)
 	}
 
 	pr.QueryKeys = map[string]bool{}
@@ -167,8 +288,11 @@ func loadPage(file string, vdtr *htmlcheck.Validator, doWordlist bool) *pageRepo
 		pr.Hrefs[href] = true
 	}
 	pr.Forms = page.RespInfo.Forms
+	pr.Technologies = fingerprintPage(settings.Technologies, page)
+	pr.Cookies, pr.RequestCookies = getCookieNames(page)
+	pr.JSLibraries = detectJSLibraries(settings.JSLibraries, page, pr)
 
-	return pr
+	return pr, nil
 }
 
 func bytesToStrings(arr [][]byte) []string {
@@ -183,7 +307,7 @@ func genReportCrawledUrls(settings *reportSettings, pageReports map[string]*page
 	path := settings.ReportFile + "/crawledurls.csv"
 	err := removeIfExists(path)
 	checkError(err)
-	file, err := os.OpenFile(path, os.O_CREATE, 0655)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
 	checkError(err)
 	defer file.Close()
 
@@ -213,7 +337,7 @@ func genReportAllUrls(settings *reportSettings, pageReports map[string]*pageRepo
 	path := settings.ReportFile + "/allUrls.csv"
 	err := removeIfExists(path)
 	checkError(err)
-	file, err := os.OpenFile(path, os.O_CREATE, 0655)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
 	checkError(err)
 	defer file.Close()
 
@@ -244,7 +368,7 @@ func genReportQueryKeys(settings *reportSettings, usedURLQueryKeys map[string]st
 	path := settings.ReportFile + "/querykeys.csv"
 	err := removeIfExists(path)
 	checkError(err)
-	file, err := os.OpenFile(path, os.O_CREATE, 0655)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
 	checkError(err)
 	defer file.Close()
 
@@ -284,7 +408,7 @@ func genReportWordlist(settings *reportSettings, pageReports map[string]*pageRep
 		return
 	}
 
-	file, err := os.OpenFile(path, os.O_CREATE, 0655)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
 	checkError(err)
 	defer file.Close()
 
@@ -303,7 +427,7 @@ func genReportInvalidTags(settings *reportSettings, pageReports map[string]*page
 	path := settings.ReportFile + "/invalidtags.csv"
 	err := removeIfExists(path)
 	checkError(err)
-	file, err := os.OpenFile(path, os.O_CREATE, 0655)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
 	checkError(err)
 	defer file.Close()
 
@@ -311,28 +435,35 @@ func genReportInvalidTags(settings *reportSettings, pageReports map[string]*page
 	csv.Comma = ';'
 
 	csv.Write([]string{"reason", "tag", "attribute", "line",
-		"file name", "url"})
+		"file name", "url", "severity"})
 
+	summary := validationSummary{}
 	for _, info := range pageReports {
 		if len(info.InvalidTags) > 0 {
-			for _, inv := range info.InvalidTags {
-				reason := fmt.Sprint(inv.Reason)
-				line := fmt.Sprint(inv.TextPos.Line)
+			for _, inv := range rateValidations(settings, info.InvalidTags) {
+				reason := reasonName(inv.Reason)
+				line := ""
+				if inv.TextPos != nil {
+					line = fmt.Sprint(inv.TextPos.Line)
+				}
 				csv.Write([]string{reason, inv.TagName, inv.AttributeName,
-					line, info.FileName, info.URL})
+					line, info.FileName, info.URL, inv.Severity})
+				summary.add(inv, info.URL)
 			}
 		}
 	}
 
 	csv.Flush()
 	checkError(csv.Error())
+
+	genReportInvalidTagsSummary(settings, summary)
 }
 
 func genReportFormsURL(settings *reportSettings, pageReports map[string]*pageReport) {
 	path := settings.ReportFile + "/formtags.csv"
 	err := removeIfExists(path)
 	checkError(err)
-	file, err := os.OpenFile(path, os.O_CREATE, 0655)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
 	checkError(err)
 	defer file.Close()
 
@@ -351,6 +482,32 @@ func genReportFormsURL(settings *reportSettings, pageReports map[string]*pageRep
 	checkError(csv.Error())
 }
 
+// loadReportNormalizer loads the url map of a crawl with -normalize, nil if
+// the crawl was not normalized.
+func loadReportNormalizer(settings *reportSettings) (*urlNormalizer, error) {
+	mapFile := path.Join(settings.StoragePath, "urlmap.csv")
+	if ok, _ := exists(mapFile); !ok {
+		return nil, nil
+	}
+	rules, err := loadNormalizeRules(settings.NormalizeConfig)
+	if err != nil {
+		return nil, err
+	}
+	n := newURLNormalizer(*rules)
+	err = n.LoadMapFile(mapFile)
+	// only looked up, never written
+	n.MapFile = ""
+	return n, err
+}
+
+// canonicalURL returns the url a link was crawled as.
+func canonicalURL(settings *reportSettings, link string) string {
+	if settings.Normalizer == nil {
+		return link
+	}
+	return settings.Normalizer.Lookup(link)
+}
+
 func loadData(settings *reportSettings) (map[string]*pageReport, map[string]string) {
 	pageReports := map[string]*pageReport{}
 	usedURLQueryKeys := map[string]string{}
@@ -359,16 +516,61 @@ func loadData(settings *reportSettings) (map[string]*pageReport, map[string]stri
 	err := vdtr.LoadTagsFromFile(settings.TagsFiles)
 	checkError(err)
 
+	settings.Technologies, err = loadTechnologies(settings.TechFile)
+	checkError(err)
+
+	settings.JSLibraries, err = loadJSLibraries(settings.JSVulnFile)
+	checkError(err)
+
+	settings.Normalizer, err = loadReportNormalizer(settings)
+	checkError(err)
+
 	files, err := crawlbase.GetPageInfoFiles(settings.StoragePath)
 	checkError(err)
 
-	for _, file := range files {
-		pr := loadPage(file, &vdtr, settings.WordList)
+	cache := loadReportCache(settings)
+	results := make([]*pageReport, len(files))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < settings.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				pr, err := loadPage(files[i], &vdtr, settings)
+				if err != nil {
+					logError(fmt.Errorf("skipping %s: %v", files[i], err))
+					continue
+				}
+				results[i] = pr
+			}
+		}()
+	}
+	for i, file := range files {
+		if pr := cache.Get(file); pr != nil {
+			results[i] = pr
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	// merged in file order, so later crawls of an url win as before
+	for i, pr := range results {
+		if pr == nil {
+			continue
+		}
+		cache.Put(files[i], pr)
 		pageReports[pr.URL] = pr
 		for url := range pr.QueryKeys {
 			usedURLQueryKeys[url] = pr.URL
 		}
 	}
+	if settings.Cache {
+		logError(cache.Save(files))
+		color.Yellow("%d page(s) processed, %d from cache", cache.misses, cache.hits)
+	}
 	return pageReports, usedURLQueryKeys
 }
 
@@ -376,6 +578,8 @@ func generateReport(settings *reportSettings) {
 	startTime := time.Now()
 
 	if settings.Profile {
+		err := os.MkdirAll(settings.ProfileFolder, 0777)
+		checkError(err)
 		f, err := os.Create(settings.ProfileFolder + "cpuprofile.pprof")
 		checkError(err)
 		pprof.StartCPUProfile(f)
@@ -386,7 +590,7 @@ func generateReport(settings *reportSettings) {
 	pages, queryKeys := loadData(settings)
 
 	if settings.Profile {
-		color.Yellow("loaded content in ", time.Now().Sub(startTime))
+		color.Yellow("loaded content in %s with %d worker(s)", time.Now().Sub(startTime), settings.Workers)
 		writeHeap(settings.ProfileFolder, "0")
 	}
 
@@ -396,6 +600,24 @@ func generateReport(settings *reportSettings) {
 	genReportWordlist(settings, pages)
 	genReportFormsURL(settings, pages)
 	genReportAllUrls(settings, pages)
+	genReportTechnologies(settings, pages)
+	genReportParameters(settings, pages)
+	genReportSecurityHeaders(settings, pages)
+	genReportBrokenLinks(settings, pages)
+	genReportArtifacts(settings, pages)
+	genReportThirdParty(settings, pages)
+	genReportLatency(settings, pages)
+	genReportRedirects(settings, pages)
+	genReportOpenAPI(settings, pages)
+	genReportDocuments(settings, pages)
+	genReportJSLibraries(settings, pages)
+	if settings.HTMLReport {
+		genReportHTML(settings, pages)
+	}
+	genReportExport(settings, pages)
+	if settings.Graph != "" {
+		genReportGraph(settings, pages)
+	}
 
 	color.Green("report generated in %s", time.Now().Sub(startTime))
 }
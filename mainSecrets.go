@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/fatih/color"
+)
+
+type secretRule struct {
+	Name       string
+	Regex      string
+	MinEntropy float64
+	regex      *regexp.Regexp
+}
+
+// secretAllow suppresses findings. Rule and URL are optional, Value is
+// matched against the found secret.
+type secretAllow struct {
+	Rule  string
+	URL   string
+	Value string
+	url   *regexp.Regexp
+	value *regexp.Regexp
+}
+
+type secretRules struct {
+	Rules     []*secretRule
+	AllowList []*secretAllow
+}
+
+type secretFinding struct {
+	URL     string
+	Line    string
+	Rule    string
+	Preview string
+	secret  string
+}
+
+type settingsSecrets struct {
+	StoragePath string
+	ConfigFile  string
+	Output      string
+}
+
+func mainSecrets() {
+	fs := flag.NewFlagSet("secrets", flag.ExitOnError)
+
+	storagePath := fs.String("storage-path", "./storage", "folder with crawled files from 'crawler'")
+	configFile := fs.String("config", "./config/secrets.json", "path to secret rules and allow list")
+	output := fs.String("output", "secrets.csv", "output file")
+
+	fs.Parse(os.Args[2:])
+
+	settings := settingsSecrets{
+		StoragePath: *storagePath,
+		ConfigFile:  *configFile,
+		Output:      *output,
+	}
+
+	rules, err := loadSecretRules(settings.ConfigFile)
+	checkError(err)
+
+	findings := scanStorageForSecrets(settings.StoragePath, rules)
+	writeSecretFindings(settings.Output, findings)
+	color.Green("%d secret(s) found, written to %s", len(findings), settings.Output)
+}
+
+func loadSecretRules(path string) (*secretRules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := &secretRules{}
+	err = json.Unmarshal(data, rules)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rules.Rules {
+		r.regex, err = regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+	}
+	for _, a := range rules.AllowList {
+		if a.URL != "" {
+			a.url, err = regexp.Compile(a.URL)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if a.Value != "" {
+			a.value, err = regexp.Compile(a.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rules, nil
+}
+
+func scanStorageForSecrets(storagePath string, rules *secretRules) []*secretFinding {
+	files, err := crawlbase.GetPageInfoFiles(storagePath)
+	checkError(err)
+
+	findings := []*secretFinding{}
+	seen := map[string]bool{}
+	for _, file := range files {
+		page, err := crawlbase.LoadPage(file, true)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		// rules are ordered from specific to generic, a secret is
+		// reported once per url by the first matching rule
+		for _, f := range scanPageForSecrets(page, rules) {
+			key := f.URL + "\x00" + f.secret
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			findings = append(findings, f)
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].URL != findings[j].URL {
+			return findings[i].URL < findings[j].URL
+		}
+		return findings[i].Rule < findings[j].Rule
+	})
+	return findings
+}
+
+func scanPageForSecrets(page *crawlbase.Page, rules *secretRules) []*secretFinding {
+	findings := []*secretFinding{}
+
+	if page.Response != nil {
+		names := []string{}
+		for name := range page.Response.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, val := range page.Response.Header[name] {
+				text := name + ": " + val
+				findings = append(findings,
+					scanTextForSecrets(page.URL, "header "+name, text, rules)...)
+			}
+		}
+	}
+
+	for i, line := range strings.Split(string(page.ResponseBody), "\n") {
+		findings = append(findings,
+			scanTextForSecrets(page.URL, strconv.Itoa(i+1), line, rules)...)
+	}
+	return findings
+}
+
+func scanTextForSecrets(pageURL, line, text string, rules *secretRules) []*secretFinding {
+	findings := []*secretFinding{}
+	for _, rule := range rules.Rules {
+		for _, match := range rule.regex.FindAllStringSubmatch(text, -1) {
+			secret := match[0]
+			if len(match) > 1 && match[1] != "" {
+				secret = match[1]
+			}
+			if rule.MinEntropy > 0 && shannonEntropy(secret) < rule.MinEntropy {
+				continue
+			}
+			if rules.isAllowed(rule.Name, pageURL, secret) {
+				continue
+			}
+			findings = append(findings, &secretFinding{
+				URL:     pageURL,
+				Line:    line,
+				Rule:    rule.Name,
+				Preview: maskSecret(secret),
+				secret:  secret,
+			})
+		}
+	}
+	return findings
+}
+
+func (rules *secretRules) isAllowed(rule, pageURL, secret string) bool {
+	for _, a := range rules.AllowList {
+		if a.Rule != "" && a.Rule != rule {
+			continue
+		}
+		if a.url != nil && !a.url.MatchString(pageURL) {
+			continue
+		}
+		if a.value != nil && !a.value.MatchString(secret) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// shannonEntropy returns the entropy in bits per character.
+func shannonEntropy(text string) float64 {
+	if text == "" {
+		return 0
+	}
+	counts := map[rune]int{}
+	total := 0
+	for _, r := range text {
+		counts[r]++
+		total++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(total)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// maskSecret keeps the first and last characters of secret, e.g. AKIA************MPLE.
+func maskSecret(secret string) string {
+	runes := []rune(secret)
+	keep := len(runes) / 5
+	if keep > 4 {
+		keep = 4
+	}
+	masked := len(runes) - 2*keep
+	return string(runes[:keep]) + strings.Repeat("*", masked) + string(runes[len(runes)-keep:])
+}
+
+func writeSecretFindings(path string, findings []*secretFinding) {
+	file, err := os.Create(path)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"url", "line", "rule", "preview"})
+	for _, f := range findings {
+		csv.Write([]string{f.URL, f.Line, f.Rule, f.Preview})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
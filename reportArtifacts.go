@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/csv"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// developer artifact types
+const (
+	artifactComment     = "comment"
+	artifactHiddenInput = "hidden-input"
+	artifactDataConfig  = "data-config"
+	artifactInlineJSON  = "inline-json"
+	artifactSourceMap   = "source-map"
+	artifactDebug       = "debug"
+	artifactInternalIP  = "internal-ip"
+)
+
+const maxArtifactLength = 300
+
+// devArtifact is something developers left in a page, Name is the input,
+// attribute or variable name if there is one.
+type devArtifact struct {
+	Type  string
+	Name  string
+	Value string
+}
+
+var regSourceMap = regexp.MustCompile(`[#@]\s*sourceMappingURL=(\S+)`)
+var regDebugString = regexp.MustCompile(`(?i)\b(TODO|FIXME|XXX|HACK)\b|Traceback \(most recent call last\)|Exception in thread|\bat [\w$.<>]+ \([^)]+:\d+(:\d+)?\)|\w+\.(?:java|py|php|rb|cs):\d+|on line \d+|stack ?trace`)
+var regStateAssign = regexp.MustCompile(`(?s)(window\.[\w$]+|__[\w$]+__)\s*=\s*([{\[].*)`)
+
+func truncate(text string) string {
+	text = strings.TrimSpace(text)
+	if len(text) > maxArtifactLength {
+		cut := maxArtifactLength
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		return text[:cut] + "..."
+	}
+	return text
+}
+
+// getDevArtifacts finds comments, hidden inputs, config blobs, inline state,
+// source maps, debug strings and internal ips in a response.
+func getDevArtifacts(body []byte, doc *goquery.Document, mime string, header http.Header) []devArtifact {
+	artifacts := []devArtifact{}
+	seen := map[devArtifact]bool{}
+	add := func(a devArtifact) {
+		a.Value = truncate(a.Value)
+		if a.Value == "" || seen[a] {
+			return
+		}
+		seen[a] = true
+		artifacts = append(artifacts, a)
+	}
+
+	for _, name := range []string{"SourceMap", "X-SourceMap"} {
+		if v := header.Get(name); v != "" {
+			add(devArtifact{artifactSourceMap, name, v})
+		}
+	}
+
+	isHTML := mime == "text/html"
+	if !isHTML && !isTextMime(mime) {
+		return artifacts
+	}
+
+	if doc != nil {
+		for _, c := range getHTMLComments(doc) {
+			add(devArtifact{artifactComment, "", c})
+		}
+		doc.Find("input[type]").Each(func(i int, s *goquery.Selection) {
+			if typ, _ := s.Attr("type"); !strings.EqualFold(typ, "hidden") {
+				return
+			}
+			name, _ := s.Attr("name")
+			value, _ := s.Attr("value")
+			add(devArtifact{artifactHiddenInput, name, value})
+		})
+		doc.Find("*").Each(func(i int, s *goquery.Selection) {
+			for _, attr := range s.Nodes[0].Attr {
+				v := strings.TrimSpace(attr.Val)
+				if strings.HasPrefix(attr.Key, "data-") && (strings.HasPrefix(v, "{") || strings.HasPrefix(v, "[")) {
+					add(devArtifact{artifactDataConfig, attr.Key, v})
+				}
+			}
+		})
+		doc.Find("script").Each(func(i int, s *goquery.Selection) {
+			typ, _ := s.Attr("type")
+			text := strings.TrimSpace(s.Text())
+			if strings.Contains(strings.ToLower(typ), "json") {
+				id, _ := s.Attr("id")
+				add(devArtifact{artifactInlineJSON, id, text})
+				return
+			}
+			if m := regStateAssign.FindStringSubmatch(text); m != nil {
+				add(devArtifact{artifactInlineJSON, m[1], m[2]})
+			}
+		})
+	}
+
+	for _, m := range regSourceMap.FindAllSubmatch(body, 10) {
+		add(devArtifact{artifactSourceMap, "", string(m[1])})
+	}
+
+	for _, line := range strings.Split(string(body), "\n") {
+		if m := regDebugString.FindString(line); m != "" {
+			add(devArtifact{artifactDebug, m, line})
+		}
+	}
+
+	for _, ip := range crawlbase.GetIPsFromText(body, 100) {
+		parsed := net.ParseIP(string(ip))
+		if parsed != nil && (parsed.IsPrivate() || parsed.IsLoopback() || parsed.IsLinkLocalUnicast()) {
+			add(devArtifact{artifactInternalIP, "", string(ip)})
+		}
+	}
+	return artifacts
+}
+
+func getHTMLComments(doc *goquery.Document) []string {
+	comments := []string{}
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.CommentNode {
+			comments = append(comments, n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range doc.Nodes {
+		walk(n)
+	}
+	return comments
+}
+
+func genReportArtifacts(settings *reportSettings, pageReports map[string]*pageReport) {
+	urls := []string{}
+	for u := range pageReports {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	// identical comments are reported once, at the first url
+	commentPages := map[string]int{}
+	for _, u := range urls {
+		for _, a := range pageReports[u].Artifacts {
+			if a.Type == artifactComment {
+				commentPages[a.Value]++
+			}
+		}
+	}
+
+	path := settings.ReportFile + "/devartifacts.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"url", "type", "name", "value", "pages"})
+
+	reported := map[string]bool{}
+	for _, u := range urls {
+		for _, a := range pageReports[u].Artifacts {
+			pages := 1
+			if a.Type == artifactComment {
+				if reported[a.Value] {
+					continue
+				}
+				reported[a.Value] = true
+				pages = commentPages[a.Value]
+			}
+			csv.Write([]string{u, a.Type, a.Name, a.Value, strconv.Itoa(pages)})
+		}
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
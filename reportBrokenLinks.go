@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// getLinkTexts returns the text of each link by absolute url, the first
+// non empty text wins.
+func getLinkTexts(doc *goquery.Document, base *url.URL) map[string]string {
+	if doc == nil {
+		return nil
+	}
+	texts := map[string]string{}
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		abs := crawlbase.ToAbsUrl(base, href)
+		if abs == "" || texts[abs] != "" {
+			return
+		}
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text == "" {
+			text, _ = s.Attr("title")
+		}
+		if text == "" {
+			text, _ = s.Find("img[alt]").Attr("alt")
+		}
+		texts[abs] = text
+	})
+	return texts
+}
+
+type linkReferrer struct {
+	Page string
+	Text string
+}
+
+// getReferrers returns all pages linking to an url, sorted by page url.
+// Links are keyed by the canonical url they were crawled as.
+func getReferrers(settings *reportSettings, pageReports map[string]*pageReport) map[string][]linkReferrer {
+	referrers := map[string][]linkReferrer{}
+	for _, p := range pageReports {
+		for href := range p.Hrefs {
+			link := canonicalURL(settings, href)
+			referrers[link] = append(referrers[link], linkReferrer{p.URL, p.LinkTexts[href]})
+		}
+		if p.Location != "" && !p.Hrefs[p.Location] {
+			link := canonicalURL(settings, p.Location)
+			referrers[link] = append(referrers[link], linkReferrer{p.URL, "(redirect)"})
+		}
+	}
+	for _, refs := range referrers {
+		sort.Slice(refs, func(i, j int) bool {
+			return refs[i].Page < refs[j].Page
+		})
+	}
+	return referrers
+}
+
+func isBrokenPage(p *pageReport) bool {
+	return p.Error != "" || p.StatusCode == 0 || p.StatusCode >= 400
+}
+
+func genReportBrokenLinks(settings *reportSettings, pageReports map[string]*pageReport) {
+	referrers := getReferrers(settings, pageReports)
+
+	broken := []*pageReport{}
+	hosts := map[string]bool{}
+	for _, p := range pageReports {
+		if pURL, err := url.Parse(p.URL); err == nil {
+			hosts[pURL.Host] = true
+		}
+		if isBrokenPage(p) {
+			broken = append(broken, p)
+		}
+	}
+	sort.Slice(broken, func(i, j int) bool {
+		return broken[i].URL < broken[j].URL
+	})
+
+	path := settings.ReportFile + "/brokenlinks.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"url", "Http code", "error", "referrer", "link text"})
+	for _, p := range broken {
+		refs := referrers[p.URL]
+		if len(refs) == 0 {
+			refs = []linkReferrer{{}}
+		}
+		for _, ref := range refs {
+			csv.Write([]string{p.URL, strconv.Itoa(p.StatusCode), p.Error, ref.Page, ref.Text})
+		}
+	}
+	csv.Flush()
+	checkError(csv.Error())
+
+	genReportUncrawledLinks(settings, pageReports, referrers, hosts)
+}
+
+// genReportUncrawledLinks lists linked urls which were not crawled. Links
+// to crawled hosts go to uncrawledlinks.csv, the others to
+// outofscopelinks.csv.
+func genReportUncrawledLinks(settings *reportSettings, pageReports map[string]*pageReport,
+	referrers map[string][]linkReferrer, hosts map[string]bool) {
+
+	inScope, outOfScope := []string{}, []string{}
+	for link := range referrers {
+		if _, ok := pageReports[link]; ok {
+			continue
+		}
+		if lURL, err := url.Parse(link); err == nil && hosts[lURL.Host] {
+			inScope = append(inScope, link)
+		} else {
+			outOfScope = append(outOfScope, link)
+		}
+	}
+	sort.Strings(inScope)
+	sort.Strings(outOfScope)
+
+	writeLinkReferrers(settings.ReportFile+"/uncrawledlinks.csv", inScope, referrers)
+	writeLinkReferrers(settings.ReportFile+"/outofscopelinks.csv", outOfScope, referrers)
+}
+
+func writeLinkReferrers(path string, links []string, referrers map[string][]linkReferrer) {
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"url", "referrer", "link text"})
+	for _, link := range links {
+		for _, ref := range referrers[link] {
+			csv.Write([]string{link, ref.Page, ref.Text})
+		}
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
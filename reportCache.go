@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// reportCache keeps the processed pages of the last report run. A page is
+// reprocessed when its .httpi or .respbin file changed, the whole cache is
+// dropped when settings or config files used by loadPage changed.
+type reportCache struct {
+	Key     string
+	Entries map[string]*cacheEntry
+	path    string
+	hits    int
+	misses  int
+}
+
+type cacheEntry struct {
+	ModTime int64
+	Size    int64
+	Page    *pageReport
+}
+
+func cacheKey(settings *reportSettings) string {
+	parts := []string{typeHash(reflect.TypeOf(pageReport{})), fmt.Sprint(settings.WordList)}
+	for _, file := range []string{settings.TagsFiles, settings.TechFile, settings.JSVulnFile} {
+		parts = append(parts, file+"@"+fileStamp(file))
+	}
+	return strings.Join(parts, ";")
+}
+
+// typeHash hashes the field names, types and tags of t, so the cache is
+// dropped when pageReport or one of its field types changes.
+func typeHash(t reflect.Type) string {
+	b := &strings.Builder{}
+	writeTypeShape(b, t, map[reflect.Type]bool{})
+	sum := sha1.Sum([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeTypeShape(b *strings.Builder, t reflect.Type, seen map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		b.WriteString(t.Kind().String() + " ")
+		writeTypeShape(b, t.Elem(), seen)
+	case reflect.Map:
+		b.WriteString("map[")
+		writeTypeShape(b, t.Key(), seen)
+		b.WriteString("]")
+		writeTypeShape(b, t.Elem(), seen)
+	case reflect.Struct:
+		b.WriteString(t.String())
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			b.WriteString(f.Name + " ")
+			writeTypeShape(b, f.Type, seen)
+			b.WriteString(" `" + string(f.Tag) + "`;")
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(t.String())
+	}
+}
+
+func fileStamp(file string) string {
+	info, err := os.Stat(file)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// pageFileStamp returns the newest modification time and the total size of
+// the page info file and its response body.
+func pageFileStamp(file string) (int64, int64) {
+	var modTime, size int64
+	for _, f := range []string{file, strings.Replace(file, ".httpi", ".respbin", 1)} {
+		info, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if t := info.ModTime().UnixNano(); t > modTime {
+			modTime = t
+		}
+		size += info.Size()
+	}
+	return modTime, size
+}
+
+func loadReportCache(settings *reportSettings) *reportCache {
+	cache := &reportCache{
+		Key:     cacheKey(settings),
+		Entries: map[string]*cacheEntry{},
+		path:    settings.ReportFile + "/reportcache.json",
+	}
+	if !settings.Cache {
+		return cache
+	}
+
+	data, err := ioutil.ReadFile(cache.path)
+	if err != nil {
+		return cache
+	}
+	stored := &reportCache{}
+	err = json.Unmarshal(data, stored)
+	if err != nil {
+		logError(err)
+		return cache
+	}
+	if stored.Key != cache.Key {
+		color.Yellow("report settings changed, ignoring cache")
+		return cache
+	}
+	cache.Entries = stored.Entries
+	return cache
+}
+
+// Get returns the cached page for file if the file is unchanged.
+func (c *reportCache) Get(file string) *pageReport {
+	modTime, size := pageFileStamp(file)
+	entry, ok := c.Entries[file]
+	if ok && entry.ModTime == modTime && entry.Size == size && entry.Page != nil {
+		c.hits++
+		return entry.Page
+	}
+	c.misses++
+	return nil
+}
+
+func (c *reportCache) Put(file string, page *pageReport) {
+	modTime, size := pageFileStamp(file)
+	c.Entries[file] = &cacheEntry{ModTime: modTime, Size: size, Page: page}
+}
+
+// Save writes the cache, entries of files not in files are dropped.
+func (c *reportCache) Save(files []string) error {
+	current := map[string]bool{}
+	for _, f := range files {
+		current[f] = true
+	}
+	for f := range c.Entries {
+		if !current[f] {
+			delete(c.Entries, f)
+		}
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(c.path), 0777)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, data, 0666)
+}
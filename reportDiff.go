@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/fatih/color"
+)
+
+// diff change types
+const (
+	diffNewURL      = "new-url"
+	diffRemovedURL  = "removed-url"
+	diffStatus      = "status"
+	diffNewForm     = "new-form"
+	diffNewInput    = "new-input"
+	diffNewQueryKey = "new-query-key"
+	diffBody        = "body-changed"
+)
+
+// diffPage holds what is compared of a stored page, the body as multiset of
+// line hashes. Bodies with few lines, like minified scripts, also keep
+// hashes of token shingles.
+type diffPage struct {
+	URL        string
+	StatusCode int
+	Forms      map[string]map[string]bool
+	Lines      map[uint64]int
+	Shingles   map[uint64]int
+}
+
+// bodies with less lines are compared by token shingles
+const minDiffLines = 10
+
+// tokens per shingle
+const shingleSize = 3
+
+var regDiffToken = regexp.MustCompile(`\w+|[^\w\s]+`)
+
+type diffChange struct {
+	Change string
+	URL    string
+	Old    string
+	New    string
+}
+
+func loadDiffPages(storagePath string) map[string]*diffPage {
+	files, err := crawlbase.GetPageInfoFiles(storagePath)
+	checkError(err)
+
+	pages := map[string]*diffPage{}
+	for _, file := range files {
+		page, err := crawlbase.LoadPage(file, true)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		dp := &diffPage{URL: page.URL, Forms: map[string]map[string]bool{}}
+		if page.Response != nil {
+			dp.StatusCode = page.Response.StatusCode
+		}
+		for _, form := range page.RespInfo.Forms {
+			key := formKey(form)
+			if dp.Forms[key] == nil {
+				dp.Forms[key] = map[string]bool{}
+			}
+			for _, input := range form.Inputs {
+				dp.Forms[key][input.Name] = true
+			}
+		}
+		dp.Lines, dp.Shingles = hashBody(string(page.ResponseBody))
+		pages[page.URL] = dp
+	}
+	return pages
+}
+
+func formKey(form crawlbase.Form) string {
+	method := strings.ToUpper(form.Method)
+	if method == "" {
+		method = "GET"
+	}
+	return strings.TrimSpace(method + " " + form.Url)
+}
+
+// hashBody returns the line hashes of body and, if it has less than
+// minDiffLines lines, the hashes of its token shingles.
+func hashBody(body string) (map[uint64]int, map[uint64]int) {
+	lines := map[uint64]int{}
+	count := 0
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines[hashString(line)]++
+		count++
+	}
+	if count >= minDiffLines {
+		return lines, nil
+	}
+
+	shingles := map[uint64]int{}
+	tokens := regDiffToken.FindAllString(body, -1)
+	if len(tokens) < shingleSize {
+		// shorter bodies are a single shingle
+		shingles[hashString(strings.Join(tokens, " "))]++
+		return lines, shingles
+	}
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		shingles[hashString(strings.Join(tokens[i:i+shingleSize], " "))]++
+	}
+	return lines, shingles
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// lineSimilarity is the jaccard index of the line multisets of two bodies,
+// of the token shingles if both bodies have few lines.
+func lineSimilarity(a, b *diffPage) float64 {
+	if a.Shingles != nil && b.Shingles != nil {
+		return jaccardIndex(a.Shingles, b.Shingles)
+	}
+	return jaccardIndex(a.Lines, b.Lines)
+}
+
+// jaccardIndex of two multisets, a repeated element counts as often as it
+// is in both.
+func jaccardIndex(a, b map[uint64]int) float64 {
+	same, all := 0, 0
+	for h, n := range a {
+		m := b[h]
+		if m < n {
+			same += m
+			all += n
+		} else {
+			same += n
+			all += m
+		}
+	}
+	for h, m := range b {
+		if _, ok := a[h]; !ok {
+			all += m
+		}
+	}
+	if all == 0 {
+		return 1
+	}
+	return float64(same) / float64(all)
+}
+
+func queryKeys(pages map[string]*diffPage) map[string]string {
+	keys := map[string]string{}
+	for u := range pages {
+		pURL, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		for k := range pURL.Query() {
+			if first, ok := keys[k]; !ok || u < first {
+				keys[k] = u
+			}
+		}
+	}
+	return keys
+}
+
+func diffStorages(oldPages, newPages map[string]*diffPage, threshold float64) []diffChange {
+	changes := []diffChange{}
+
+	for u, np := range newPages {
+		op, ok := oldPages[u]
+		if !ok {
+			changes = append(changes, diffChange{diffNewURL, u, "", strconv.Itoa(np.StatusCode)})
+			for key := range np.Forms {
+				changes = append(changes, diffChange{diffNewForm, u, "", key})
+			}
+			continue
+		}
+		if op.StatusCode != np.StatusCode {
+			changes = append(changes, diffChange{diffStatus, u,
+				strconv.Itoa(op.StatusCode), strconv.Itoa(np.StatusCode)})
+		}
+		for key, inputs := range np.Forms {
+			oldInputs, ok := op.Forms[key]
+			if !ok {
+				changes = append(changes, diffChange{diffNewForm, u, "", key})
+				continue
+			}
+			for input := range inputs {
+				if !oldInputs[input] {
+					changes = append(changes, diffChange{diffNewInput, u, key, input})
+				}
+			}
+		}
+		similarity := lineSimilarity(op, np)
+		if similarity < threshold {
+			changes = append(changes, diffChange{diffBody, u, "",
+				fmt.Sprintf("%.0f%% similar", similarity*100)})
+		}
+	}
+	for u, op := range oldPages {
+		if _, ok := newPages[u]; !ok {
+			changes = append(changes, diffChange{diffRemovedURL, u, strconv.Itoa(op.StatusCode), ""})
+		}
+	}
+
+	oldKeys := queryKeys(oldPages)
+	for k, u := range queryKeys(newPages) {
+		if _, ok := oldKeys[k]; !ok {
+			changes = append(changes, diffChange{diffNewQueryKey, u, "", k})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		if changes[i].URL != changes[j].URL {
+			return changes[i].URL < changes[j].URL
+		}
+		return changes[i].New < changes[j].New
+	})
+	return changes
+}
+
+// genReportDiff compares two crawl storages by url and writes diff.csv.
+func genReportDiff(settings *reportSettings, oldStorage, newStorage string) {
+	oldPages := loadDiffPages(oldStorage)
+	newPages := loadDiffPages(newStorage)
+	changes := diffStorages(oldPages, newPages, settings.DiffThreshold)
+
+	err := os.MkdirAll(settings.ReportFile, 0777)
+	checkError(err)
+	path := settings.ReportFile + "/diff.csv"
+	err = removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"change", "url", "old", "new"})
+	for _, c := range changes {
+		csv.Write([]string{c.Change, c.URL, c.Old, c.New})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+
+	color.Green("%d change(s) between %s (%d pages) and %s (%d pages) written to %s",
+		len(changes), oldStorage, len(oldPages), newStorage, len(newPages), path)
+}
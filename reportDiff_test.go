@@ -0,0 +1,48 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineSimilarity(t *testing.T) {
+	lines := func(n int, prefix string) string {
+		l := []string{}
+		for i := 0; i < n; i++ {
+			l = append(l, prefix+strings.Repeat("x", i))
+		}
+		return strings.Join(l, "\n")
+	}
+	tests := []struct {
+		name     string
+		old, new string
+		min, max float64
+	}{
+		{"empty", "", "", 1, 1},
+		{"empty and text", "", "a", 0, 0},
+		{"same lines", lines(20, "line "), lines(20, "line "), 1, 1},
+		{"different lines", lines(20, "old "), lines(20, "new "), 0, 0},
+		{"one of ten lines changed", lines(10, "line ") + "\nold", lines(10, "line ") + "\nnew", 0.8, 0.9},
+		{"whitespace and empty lines", lines(20, "line "), "\n  " + strings.Replace(lines(20, "line "), "\n", "\n\n", -1), 1, 1},
+		{"duplicate lines", lines(10, "line ") + strings.Repeat("\nsame", 10), lines(10, "line ") + "\nsame", 0.5, 0.6},
+		{"same single line", "var a=1;function b(){return a}", "var a=1;function b(){return a}", 1, 1},
+		{"small change in single line", "var a=1;function b(){return a+c+d+e+f}", "var a=2;function b(){return a+c+d+e+f}", 0.6, 0.9},
+		{"different single line", "var a=1;function b(){return a}", "<html><body>error</body></html>", 0, 0.1},
+		{"short bodies", "ok", "ok", 1, 1},
+		{"reordered tokens", "a b c d e f", "f e d c b a", 0, 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := &diffPage{}, &diffPage{}
+			a.Lines, a.Shingles = hashBody(tt.old)
+			b.Lines, b.Shingles = hashBody(tt.new)
+			got := lineSimilarity(a, b)
+			if got < tt.min || got > tt.max {
+				t.Errorf("lineSimilarity() = %.2f, want %.2f to %.2f", got, tt.min, tt.max)
+			}
+			if reverse := lineSimilarity(b, a); reverse != got {
+				t.Errorf("lineSimilarity() is not symmetric, %.2f and %.2f", got, reverse)
+			}
+		})
+	}
+}
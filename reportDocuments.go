@@ -0,0 +1,514 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/csv"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// document metadata fields
+const (
+	docAuthor   = "author"
+	docUsername = "username"
+	docSoftware = "software"
+	docCompany  = "company"
+	docTitle    = "title"
+	docCreated  = "created"
+	docModified = "modified"
+	docPath     = "internal-path"
+	docLink     = "link"
+	docDevice   = "device"
+	docGPS      = "gps"
+)
+
+const maxDocumentSize = 50 << 20
+
+// maxInflateSize limits the inflated bytes of all streams or entries of a
+// document
+var maxInflateSize int64 = maxDocumentSize
+
+// docMetadata is a field found in a pdf, office document or image.
+type docMetadata struct {
+	Type  string
+	Field string
+	Value string
+}
+
+type docMetadataList struct {
+	items []docMetadata
+	seen  map[docMetadata]bool
+}
+
+func (l *docMetadataList) add(typ, field, value string) {
+	value = strings.TrimSpace(strings.Trim(value, "\x00"))
+	if value == "" {
+		return
+	}
+	m := docMetadata{typ, field, value}
+	if l.seen[m] {
+		return
+	}
+	l.seen[m] = true
+	l.items = append(l.items, m)
+}
+
+// getDocumentMetadata detects pdf, office (zip) and image files by content
+// and extracts their metadata.
+func getDocumentMetadata(body []byte) []docMetadata {
+	if len(body) == 0 || len(body) > maxDocumentSize {
+		return nil
+	}
+	list := &docMetadataList{seen: map[docMetadata]bool{}}
+	switch {
+	case bytes.HasPrefix(body, []byte("%PDF")):
+		getPDFMetadata(body, list)
+	case bytes.HasPrefix(body, []byte("PK\x03\x04")):
+		getOfficeMetadata(body, list)
+	case bytes.HasPrefix(body, []byte("\xff\xd8")):
+		getJPEGMetadata(body, list)
+		getXMPMetadata("image", body, list)
+	case bytes.HasPrefix(body, []byte("II*\x00")) || bytes.HasPrefix(body, []byte("MM\x00*")):
+		getTIFFMetadata("image", body, list)
+		getXMPMetadata("image", body, list)
+	case bytes.HasPrefix(body, []byte("\x89PNG")):
+		getXMPMetadata("image", body, list)
+	default:
+		return nil
+	}
+	return list.items
+}
+
+var regInternalPath = regexp.MustCompile(`(?i)\b[a-z]:\\(?:[^\\/:*?"<>|\r\n\x00]+\\)*[^\\/:*?"<>|\r\n\x00]*|\\\\[\w.$-]+\\[^\s"<>|\x00]+|/(?:Users|home)/[\w.-]+(?:/[^\s"<>()\x00]*)?`)
+var regPathUser = regexp.MustCompile(`(?i)(?:\\Users\\|\\Documents and Settings\\|/Users/|/home/)([\w.-]+)`)
+var regDocURL = regexp.MustCompile(`https?://[^\s"'<>()\\\x00]+`)
+
+// xml namespace hosts, not links
+var regSchemaURL = regexp.MustCompile(`^https?://(ns\.adobe\.com|www\.w3\.org|purl\.org|schemas\.openxmlformats\.org|schemas\.microsoft\.com|iptc\.org|cipa\.jp)/`)
+
+// addPathsAndLinks adds internal paths, the usernames in them and links
+// found in text.
+func addPathsAndLinks(typ, text string, list *docMetadataList) {
+	addInternalPaths(typ, text, list)
+	for _, l := range regDocURL.FindAllString(text, 200) {
+		if !regSchemaURL.MatchString(l) {
+			list.add(typ, docLink, strings.TrimRight(l, ".,;"))
+		}
+	}
+}
+
+// addInternalPaths adds internal paths found in text and the usernames in
+// them, shared profile folders are no usernames.
+func addInternalPaths(typ, text string, list *docMetadataList) {
+	for _, p := range regInternalPath.FindAllString(text, 50) {
+		list.add(typ, docPath, p)
+		if m := regPathUser.FindStringSubmatch(p); m != nil {
+			switch strings.ToLower(m[1]) {
+			case "public", "default", "all users", "shared":
+			default:
+				list.add(typ, docUsername, m[1])
+			}
+		}
+	}
+}
+
+var regPDFInfo = regexp.MustCompile(`/(Author|Creator|Producer|Title|CreationDate|ModDate|Company|LastModifiedBy)\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+var regPDFURI = regexp.MustCompile(`/URI\s*\(((?:\\.|[^\\)])*)\)`)
+var regPDFStream = regexp.MustCompile(`(?s)stream\r?\n(.*?)\r?\nendstream`)
+
+var pdfFields = map[string]string{
+	"Author":         docAuthor,
+	"LastModifiedBy": docAuthor,
+	"Creator":        docSoftware,
+	"Producer":       docSoftware,
+	"Title":          docTitle,
+	"CreationDate":   docCreated,
+	"ModDate":        docModified,
+	"Company":        docCompany,
+}
+
+func getPDFMetadata(body []byte, list *docMetadataList) {
+	scanPDFText(body, list)
+	// compressed object streams hold the info dictionary in newer pdfs,
+	// all streams of a document share one inflate budget
+	budget := maxInflateSize
+	for _, m := range regPDFStream.FindAllSubmatchIndex(body, 500) {
+		if budget <= 0 {
+			break
+		}
+		r, err := zlib.NewReader(bytes.NewReader(body[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		data, _ := ioutil.ReadAll(io.LimitReader(r, budget))
+		r.Close()
+		budget -= int64(len(data))
+		scanPDFText(data, list)
+	}
+	for _, field := range list.items {
+		if field.Field == docAuthor {
+			list.add("pdf", docUsername, field.Value)
+		}
+	}
+}
+
+// scanPDFText adds the info dictionary fields, links and xmp metadata of a
+// pdf body or an inflated stream.
+func scanPDFText(text []byte, list *docMetadataList) {
+	for _, m := range regPDFInfo.FindAllSubmatch(text, 100) {
+		list.add("pdf", pdfFields[string(m[1])], decodePDFString(m[2]))
+	}
+	for _, m := range regPDFURI.FindAllSubmatch(text, 500) {
+		list.add("pdf", docLink, decodePDFString(append(append([]byte("("), m[1]...), ')')))
+	}
+	getXMPMetadata("pdf", text, list)
+}
+
+// decodePDFString decodes a literal (...) or hex <...> pdf string, utf-16
+// if it starts with a byte order mark.
+func decodePDFString(raw []byte) string {
+	var data []byte
+	if len(raw) >= 2 && raw[0] == '<' {
+		hex := strings.Join(strings.Fields(string(raw[1:len(raw)-1])), "")
+		if len(hex)%2 == 1 {
+			hex += "0"
+		}
+		for i := 0; i+1 < len(hex); i += 2 {
+			var b byte
+			for _, c := range hex[i : i+2] {
+				b <<= 4
+				switch {
+				case c >= '0' && c <= '9':
+					b |= byte(c - '0')
+				case c >= 'a' && c <= 'f':
+					b |= byte(c-'a') + 10
+				case c >= 'A' && c <= 'F':
+					b |= byte(c-'A') + 10
+				}
+			}
+			data = append(data, b)
+		}
+	} else if len(raw) >= 2 {
+		inner := raw[1 : len(raw)-1]
+		for i := 0; i < len(inner); i++ {
+			c := inner[i]
+			if c != '\\' || i+1 >= len(inner) {
+				data = append(data, c)
+				continue
+			}
+			i++
+			switch inner[i] {
+			case 'n':
+				data = append(data, '\n')
+			case 'r':
+				data = append(data, '\r')
+			case 't':
+				data = append(data, '\t')
+			case '0', '1', '2', '3', '4', '5', '6', '7':
+				v := 0
+				j := i
+				for ; j < len(inner) && j < i+3 && inner[j] >= '0' && inner[j] <= '7'; j++ {
+					v = v*8 + int(inner[j]-'0')
+				}
+				data = append(data, byte(v))
+				i = j - 1
+			default:
+				data = append(data, inner[i])
+			}
+		}
+	}
+
+	if len(data) >= 2 && data[0] == 0xfe && data[1] == 0xff {
+		return decodeUTF16(data[2:], binary.BigEndian)
+	}
+	return string(data)
+}
+
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	u := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		u = append(u, order.Uint16(data[i:]))
+	}
+	return string(utf16.Decode(u))
+}
+
+var regXMPFields = []struct {
+	Field string
+	Regex *regexp.Regexp
+}{
+	{docAuthor, regexp.MustCompile(`(?s)<dc:creator>.*?<rdf:li[^>]*>([^<]+)</rdf:li>`)},
+	{docSoftware, regexp.MustCompile(`<xmp:CreatorTool>([^<]+)<`)},
+	{docSoftware, regexp.MustCompile(`xmp:CreatorTool="([^"]+)"`)},
+	{docSoftware, regexp.MustCompile(`<pdf:Producer>([^<]+)<`)},
+	{docSoftware, regexp.MustCompile(`pdf:Producer="([^"]+)"`)},
+	{docSoftware, regexp.MustCompile(`<stEvt:softwareAgent>([^<]+)<`)},
+	{docSoftware, regexp.MustCompile(`stEvt:softwareAgent="([^"]+)"`)},
+	{docCreated, regexp.MustCompile(`<xmp:CreateDate>([^<]+)<`)},
+	{docCreated, regexp.MustCompile(`xmp:CreateDate="([^"]+)"`)},
+}
+
+func getXMPMetadata(typ string, body []byte, list *docMetadataList) {
+	start := bytes.Index(body, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return
+	}
+	end := bytes.Index(body[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		end = len(body) - start
+	}
+	xmp := string(body[start : start+end])
+	for _, f := range regXMPFields {
+		for _, m := range f.Regex.FindAllStringSubmatch(xmp, 20) {
+			list.add(typ, f.Field, m[1])
+			if f.Field == docAuthor {
+				list.add(typ, docUsername, m[1])
+			}
+		}
+	}
+	addPathsAndLinks(typ, xmp, list)
+}
+
+var regXMLElement = regexp.MustCompile(`<(dc:creator|cp:lastModifiedBy|dc:title|dcterms:created|dcterms:modified|Application|AppVersion|Company|Manager|Template)(?:\s[^>]*)?>([^<]*)<`)
+var regRelTarget = regexp.MustCompile(`Target="([^"]+)"[^>]*TargetMode="External"|TargetMode="External"[^>]*Target="([^"]+)"`)
+
+var officeFields = map[string]string{
+	"dc:creator":        docAuthor,
+	"cp:lastModifiedBy": docAuthor,
+	"dc:title":          docTitle,
+	"dcterms:created":   docCreated,
+	"dcterms:modified":  docModified,
+	"Application":       docSoftware,
+	"Company":           docCompany,
+	"Manager":           docAuthor,
+	"Template":          docPath,
+}
+
+// getOfficeMetadata reads docx, xlsx and pptx files: core and app
+// properties, external relationships and paths in the document xml.
+func getOfficeMetadata(body []byte, list *docMetadataList) {
+	r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return
+	}
+	typ := "office"
+	for _, f := range r.File {
+		switch {
+		case strings.HasPrefix(f.Name, "word/"):
+			typ = "docx"
+		case strings.HasPrefix(f.Name, "xl/"):
+			typ = "xlsx"
+		case strings.HasPrefix(f.Name, "ppt/"):
+			typ = "pptx"
+		}
+	}
+
+	appName := ""
+	// all entries of a document share one inflate budget, the sizes in
+	// the zip headers are not trusted
+	budget := maxInflateSize
+	for _, f := range r.File {
+		if budget <= 0 {
+			break
+		}
+		if !strings.HasSuffix(f.Name, ".xml") && !strings.HasSuffix(f.Name, ".rels") {
+			continue
+		}
+		if f.UncompressedSize64 > uint64(budget) {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			continue
+		}
+		data, _ := ioutil.ReadAll(io.LimitReader(rc, budget))
+		rc.Close()
+		budget -= int64(len(data))
+		text := string(data)
+
+		if strings.HasPrefix(f.Name, "docProps/") {
+			for _, m := range regXMLElement.FindAllStringSubmatch(text, 50) {
+				if m[1] == "AppVersion" {
+					if appName != "" {
+						list.add(typ, docSoftware, appName+" "+m[2])
+					}
+					continue
+				}
+				if m[1] == "Application" {
+					appName = m[2]
+				}
+				field := officeFields[m[1]]
+				list.add(typ, field, m[2])
+				if field == docAuthor {
+					list.add(typ, docUsername, m[2])
+				}
+			}
+		}
+		if strings.HasSuffix(f.Name, ".rels") {
+			for _, m := range regRelTarget.FindAllStringSubmatch(text, 500) {
+				target := m[1] + m[2]
+				if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+					list.add(typ, docLink, target)
+				} else {
+					list.add(typ, docPath, target)
+				}
+			}
+			continue
+		}
+		addInternalPaths(typ, text, list)
+	}
+}
+
+// getJPEGMetadata finds the exif segment of a jpeg.
+func getJPEGMetadata(body []byte, list *docMetadataList) {
+	i := 2
+	for i+4 <= len(body) && body[i] == 0xff {
+		marker := body[i+1]
+		size := int(binary.BigEndian.Uint16(body[i+2:]))
+		if marker == 0xda || size < 2 || i+2+size > len(body) {
+			return
+		}
+		segment := body[i+4 : i+2+size]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			getTIFFMetadata("image", segment[6:], list)
+		}
+		i += 2 + size
+	}
+}
+
+var exifFields = map[uint16]string{
+	0x010e: docTitle,
+	0x010f: docDevice,
+	0x0110: docDevice,
+	0x0131: docSoftware,
+	0x0132: docModified,
+	0x013b: docAuthor,
+	0x8298: docAuthor,
+	0x9c9d: docAuthor,
+	0x9003: docCreated,
+}
+
+// getTIFFMetadata reads ascii fields of IFD0 and the exif IFD.
+func getTIFFMetadata(typ string, data []byte, list *docMetadataList) {
+	if len(data) < 8 {
+		return
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+
+	var readIFD func(offset uint32, depth int)
+	readIFD = func(offset uint32, depth int) {
+		if depth > 2 || int(offset)+2 > len(data) {
+			return
+		}
+		count := int(order.Uint16(data[offset:]))
+		for n := 0; n < count; n++ {
+			pos := int(offset) + 2 + n*12
+			if pos+12 > len(data) {
+				return
+			}
+			tag := order.Uint16(data[pos:])
+			kind := order.Uint16(data[pos+2:])
+			length := order.Uint32(data[pos+4:])
+			valueOffset := order.Uint32(data[pos+8:])
+
+			switch tag {
+			case 0x8769:
+				readIFD(valueOffset, depth+1)
+				continue
+			case 0x8825:
+				list.add(typ, docGPS, "gps position embedded")
+				continue
+			}
+			field, ok := exifFields[tag]
+			if !ok || (kind != 2 && kind != 1) {
+				continue
+			}
+			var value []byte
+			if length <= 4 {
+				value = data[pos+8 : pos+8+int(length)]
+			} else if int(valueOffset)+int(length) <= len(data) {
+				value = data[valueOffset : valueOffset+length]
+			}
+			text := string(value)
+			if tag == 0x9c9d {
+				text = decodeUTF16(value, binary.LittleEndian)
+			}
+			list.add(typ, field, text)
+			if field == docAuthor {
+				list.add(typ, docUsername, text)
+			}
+		}
+	}
+	readIFD(order.Uint32(data[4:]), 0)
+}
+
+func genReportDocuments(settings *reportSettings, pageReports map[string]*pageReport) {
+	urls := []string{}
+	for u, p := range pageReports {
+		if len(p.DocMetadata) > 0 {
+			urls = append(urls, u)
+		}
+	}
+	sort.Strings(urls)
+
+	path := settings.ReportFile + "/documents.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"url", "type", "field", "value"})
+
+	usernames := map[string]bool{}
+	links := map[string]bool{}
+	for _, u := range urls {
+		base, _ := url.Parse(u)
+		for _, m := range pageReports[u].DocMetadata {
+			csv.Write([]string{u, m.Type, m.Field, m.Value})
+			switch m.Field {
+			case docUsername:
+				usernames[m.Value] = true
+			case docLink:
+				link := m.Value
+				if base != nil {
+					if l, err := base.Parse(link); err == nil {
+						link = l.String()
+					}
+				}
+				if _, crawled := pageReports[link]; !crawled {
+					links[link] = true
+				}
+			}
+		}
+	}
+	csv.Flush()
+	checkError(csv.Error())
+
+	// for 'wordlist -input usernames.txt -extractor none -mutator username'
+	writeLines(settings.ReportFile+"/usernames.txt", sortedKeys(usernames))
+	// for 'crawler -url-list documentlinks.txt'
+	writeLines(settings.ReportFile+"/documentlinks.txt", sortedKeys(links))
+}
+
+func writeLines(path string, lines []string) {
+	text := strings.Join(lines, "\n")
+	if text != "" {
+		text += "\n"
+	}
+	err := ioutil.WriteFile(path, []byte(text), 0666)
+	checkError(err)
+}
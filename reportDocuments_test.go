@@ -0,0 +1,159 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type zipEntry struct {
+	Name string
+	Body string
+}
+
+func testZip(t *testing.T, entries ...zipEntry) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, e := range entries {
+		f, err := w.Create(e.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(e.Body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testPDFStream(t *testing.T, text string) string {
+	buf := &bytes.Buffer{}
+	w, err := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(text))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(text)) {
+		t.Fatalf("stream %q is not compressed", text)
+	}
+	return "stream\n" + buf.String() + "\nendstream\n"
+}
+
+// metadataTexts returns the sorted "type field value" lines of metadata.
+func metadataTexts(metadata []docMetadata) []string {
+	texts := []string{}
+	for _, m := range metadata {
+		texts = append(texts, m.Type+" "+m.Field+" "+m.Value)
+	}
+	sort.Strings(texts)
+	return texts
+}
+
+func TestGetDocumentMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want []string
+	}{
+		{"not a document", []byte("<html></html>"), []string{}},
+		{
+			"pdf info",
+			[]byte("%PDF-1.4\n1 0 obj << /Author (John Doe) /Producer (Writer\\051) /Title <FEFF00410042> >>"),
+			[]string{"pdf author John Doe", "pdf software Writer)", "pdf title AB", "pdf username John Doe"},
+		},
+		{
+			"pdf link",
+			[]byte("%PDF-1.4\n1 0 obj << /URI (http://intranet.example/a) >>"),
+			[]string{"pdf link http://intranet.example/a"},
+		},
+		{
+			"pdf compressed stream",
+			[]byte("%PDF-1.5\n" + testPDFStream(t, "<< /Author (Jane) >>")),
+			[]string{"pdf author Jane", "pdf username Jane"},
+		},
+		{
+			"docx",
+			testZip(t,
+				zipEntry{"word/document.xml", `<w:t>see C:\Users\jdoe\Documents\plan.docx</w:t>`},
+				zipEntry{"word/_rels/document.xml.rels", `<Relationship Target="http://intranet.example/a" TargetMode="External"/>`},
+				zipEntry{"docProps/core.xml", `<dc:creator>John Doe</dc:creator><dc:title>Plan</dc:title>`},
+				zipEntry{"docProps/app.xml", `<Application>Microsoft Office Word</Application><AppVersion>16.0000</AppVersion><Company>ACME</Company>`},
+			),
+			[]string{
+				`docx author John Doe`,
+				`docx company ACME`,
+				`docx internal-path C:\Users\jdoe\Documents\plan.docx`,
+				`docx link http://intranet.example/a`,
+				`docx software Microsoft Office Word`,
+				`docx software Microsoft Office Word 16.0000`,
+				`docx title Plan`,
+				`docx username John Doe`,
+				`docx username jdoe`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metadataTexts(getDocumentMetadata(tt.body)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getDocumentMetadata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInflateBudget(t *testing.T) {
+	defer func(size int64) { maxInflateSize = size }(maxInflateSize)
+	maxInflateSize = 100
+
+	// padded, so the streams are compressed and not found in the raw body
+	padding := strings.Repeat(" ", 40)
+	tests := []struct {
+		name string
+		body []byte
+		want []string
+	}{
+		{
+			"pdf streams within budget",
+			[]byte("%PDF-1.5\n" + testPDFStream(t, "/Author (A)"+padding) + testPDFStream(t, "/Title (B)"+padding)),
+			[]string{"pdf author A", "pdf title B", "pdf username A"},
+		},
+		{
+			"pdf stream truncated",
+			[]byte("%PDF-1.5\n" + testPDFStream(t, padding+padding+padding+"/Author (A)")),
+			[]string{},
+		},
+		{
+			"pdf streams after budget",
+			[]byte("%PDF-1.5\n" + testPDFStream(t, "/Author (A)"+padding+padding+padding) + testPDFStream(t, "/Title (B)"+padding)),
+			[]string{"pdf author A", "pdf username A"},
+		},
+		{
+			"office entry larger than budget",
+			testZip(t, zipEntry{"docProps/core.xml", "<dc:creator>A</dc:creator>" + padding + padding}),
+			[]string{},
+		},
+		{
+			"office entries after budget",
+			testZip(t,
+				zipEntry{"docProps/core.xml", "<dc:creator>A</dc:creator>" + padding},
+				zipEntry{"docProps/app.xml", "<Company>B</Company>" + padding},
+			),
+			[]string{"office author A", "office username A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metadataTexts(getDocumentMetadata(tt.body)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getDocumentMetadata() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
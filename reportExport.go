@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	_ "modernc.org/sqlite"
+)
+
+// export formats of 'report -format', csv reports are always written
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatJSONL  = "jsonl"
+	formatSQLite = "sqlite"
+)
+
+type exportPage struct {
+	URL              string             `json:"url"`
+	FileName         string             `json:"file"`
+	StatusCode       int                `json:"status"`
+	ContentType      string             `json:"contentType"`
+	RespDuration     int                `json:"durationMs"`
+	Location         string             `json:"location,omitempty"`
+	Error            string             `json:"error,omitempty"`
+	Links            []string           `json:"links"`
+	QueryKeys        []string           `json:"queryKeys"`
+	Forms            []exportForm       `json:"forms"`
+	ValidationErrors []exportValidation `json:"validationErrors"`
+	Words            []string           `json:"words,omitempty"`
+}
+
+type exportForm struct {
+	Action string        `json:"action"`
+	Method string        `json:"method"`
+	Inputs []exportInput `json:"inputs"`
+}
+
+type exportInput struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type exportValidation struct {
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	Tag       string `json:"tag"`
+	Attribute string `json:"attribute,omitempty"`
+	Reason    int    `json:"reason"`
+	Message   string `json:"message"`
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func toExportPage(p *pageReport) *exportPage {
+	ep := &exportPage{
+		URL:              p.URL,
+		FileName:         p.FileName,
+		StatusCode:       p.StatusCode,
+		ContentType:      p.ContentType,
+		RespDuration:     p.RespDuration,
+		Location:         p.Location,
+		Error:            p.Error,
+		Links:            sortedKeys(p.Hrefs),
+		QueryKeys:        sortedKeys(p.QueryKeys),
+		Forms:            []exportForm{},
+		ValidationErrors: []exportValidation{},
+	}
+	for _, f := range p.Forms {
+		form := exportForm{Action: f.Url, Method: f.Method, Inputs: []exportInput{}}
+		for _, in := range f.Inputs {
+			form.Inputs = append(form.Inputs, exportInput{in.Name, in.Type, in.Value})
+		}
+		ep.Forms = append(ep.Forms, form)
+	}
+	for _, v := range p.InvalidTags {
+		ev := exportValidation{
+			Tag:       v.TagName,
+			Attribute: v.AttributeName,
+			Reason:    int(v.Reason),
+			Message:   v.Error(),
+		}
+		if v.TextPos != nil {
+			ev.Line = v.TextPos.Line
+			ev.Column = v.TextPos.Column
+		}
+		ep.ValidationErrors = append(ep.ValidationErrors, ev)
+	}
+	for _, w := range p.Words {
+		if w != "" {
+			ep.Words = append(ep.Words, w)
+		}
+	}
+	return ep
+}
+
+func exportPages(pageReports map[string]*pageReport) []*exportPage {
+	pages := []*exportPage{}
+	for _, p := range pageReports {
+		pages = append(pages, toExportPage(p))
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].URL < pages[j].URL
+	})
+	return pages
+}
+
+func genReportExport(settings *reportSettings, pageReports map[string]*pageReport) {
+	switch settings.Format {
+	case formatCSV:
+		return
+	case formatJSON:
+		genReportJSON(settings.ReportFile+"/report.json", pageReports)
+	case formatJSONL:
+		genReportJSONL(settings.ReportFile+"/report.jsonl", pageReports)
+	case formatSQLite:
+		genReportSQLite(settings.ReportFile+"/report.db", pageReports)
+	default:
+		checkError(fmt.Errorf("unknown format %s", settings.Format))
+	}
+}
+
+func genReportJSON(path string, pageReports map[string]*pageReport) {
+	file, err := os.Create(path)
+	checkError(err)
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "\t")
+	err = enc.Encode(exportPages(pageReports))
+	checkError(err)
+}
+
+// genReportJSONL writes one page per line.
+func genReportJSONL(path string, pageReports map[string]*pageReport) {
+	file, err := os.Create(path)
+	checkError(err)
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	for _, p := range exportPages(pageReports) {
+		err = enc.Encode(p)
+		checkError(err)
+	}
+	checkError(w.Flush())
+}
+
+var sqliteSchema = []string{
+	`CREATE TABLE pages (id INTEGER PRIMARY KEY, url TEXT, file TEXT, status INTEGER,
+		content_type TEXT, duration_ms INTEGER, location TEXT, error TEXT)`,
+	`CREATE TABLE links (page_id INTEGER REFERENCES pages(id), url TEXT)`,
+	`CREATE TABLE query_keys (page_id INTEGER REFERENCES pages(id), name TEXT)`,
+	`CREATE TABLE forms (id INTEGER PRIMARY KEY, page_id INTEGER REFERENCES pages(id),
+		action TEXT, method TEXT)`,
+	`CREATE TABLE inputs (form_id INTEGER REFERENCES forms(id), name TEXT, type TEXT, value TEXT)`,
+	`CREATE TABLE validation_errors (page_id INTEGER REFERENCES pages(id), line INTEGER,
+		col INTEGER, tag TEXT, attribute TEXT, reason INTEGER, message TEXT)`,
+	`CREATE TABLE words (page_id INTEGER REFERENCES pages(id), word TEXT)`,
+	`CREATE INDEX idx_links_url ON links(url)`,
+	`CREATE INDEX idx_words_word ON words(word)`,
+}
+
+// genReportSQLite writes the pages into a new sqlite database, one table
+// per nested list.
+func genReportSQLite(path string, pageReports map[string]*pageReport) {
+	err := removeIfExists(path)
+	checkError(err)
+
+	db, err := sql.Open("sqlite", path)
+	checkError(err)
+	defer db.Close()
+
+	for _, stmt := range sqliteSchema {
+		_, err = db.Exec(stmt)
+		checkError(err)
+	}
+
+	tx, err := db.Begin()
+	checkError(err)
+	for i, p := range exportPages(pageReports) {
+		err = insertExportPage(tx, int64(i+1), p)
+		if err != nil {
+			tx.Rollback()
+			checkError(err)
+		}
+	}
+	checkError(tx.Commit())
+}
+
+func insertExportPage(tx *sql.Tx, pageID int64, p *exportPage) error {
+	_, err := tx.Exec(`INSERT INTO pages VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, pageID, p.URL,
+		p.FileName, p.StatusCode, p.ContentType, p.RespDuration, p.Location, p.Error)
+	if err != nil {
+		return err
+	}
+	for _, l := range p.Links {
+		if _, err = tx.Exec(`INSERT INTO links VALUES (?, ?)`, pageID, l); err != nil {
+			return err
+		}
+	}
+	for _, k := range p.QueryKeys {
+		if _, err = tx.Exec(`INSERT INTO query_keys VALUES (?, ?)`, pageID, k); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.Forms {
+		res, err := tx.Exec(`INSERT INTO forms (page_id, action, method) VALUES (?, ?, ?)`,
+			pageID, f.Action, f.Method)
+		if err != nil {
+			return err
+		}
+		formID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, in := range f.Inputs {
+			_, err = tx.Exec(`INSERT INTO inputs VALUES (?, ?, ?, ?)`, formID, in.Name, in.Type, in.Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, v := range p.ValidationErrors {
+		_, err = tx.Exec(`INSERT INTO validation_errors VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			pageID, v.Line, v.Column, v.Tag, v.Attribute, v.Reason, v.Message)
+		if err != nil {
+			return err
+		}
+	}
+	for _, w := range p.Words {
+		if _, err = tx.Exec(`INSERT INTO words VALUES (?, ?)`, pageID, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
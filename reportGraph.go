@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type graphNode struct {
+	ID          int    `json:"id"`
+	URL         string `json:"url"`
+	Host        string `json:"host"`
+	Crawled     bool   `json:"crawled"`
+	StatusCode  int    `json:"status"`
+	ContentType string `json:"contentType"`
+	// Depth is the click distance from the entry point, -1 if unreachable
+	Depth int `json:"depth"`
+}
+
+type graphEdge struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+type linkGraph struct {
+	Root  string       `json:"root"`
+	Nodes []*graphNode `json:"nodes"`
+	Edges []graphEdge  `json:"edges"`
+}
+
+// buildLinkGraph creates the directed link graph of all crawled pages and
+// their links. The entry point is the first crawled page. Links point to
+// the canonical url they were crawled as.
+func buildLinkGraph(settings *reportSettings, pageReports map[string]*pageReport) *linkGraph {
+	g := &linkGraph{Nodes: []*graphNode{}, Edges: []graphEdge{}}
+	ids := map[string]int{}
+
+	urls := []string{}
+	firstCrawl := -1
+	for u, p := range pageReports {
+		urls = append(urls, u)
+		crawlTime, _ := strconv.Atoi(p.FileName)
+		if firstCrawl < 0 || crawlTime < firstCrawl ||
+			(crawlTime == firstCrawl && u < g.Root) {
+			firstCrawl = crawlTime
+			g.Root = u
+		}
+	}
+	sort.Strings(urls)
+
+	node := func(u string) int {
+		id, ok := ids[u]
+		if !ok {
+			id = len(g.Nodes)
+			ids[u] = id
+			n := &graphNode{ID: id, URL: u, Depth: -1}
+			if pURL, err := url.Parse(u); err == nil {
+				n.Host = pURL.Host
+			}
+			g.Nodes = append(g.Nodes, n)
+		}
+		return id
+	}
+
+	for _, u := range urls {
+		p := pageReports[u]
+		n := g.Nodes[node(u)]
+		n.Crawled = true
+		n.StatusCode = p.StatusCode
+		n.ContentType = p.ContentType
+	}
+	adjacency := map[int][]int{}
+	for _, u := range urls {
+		p := pageReports[u]
+		from := ids[u]
+		targets := sortedKeys(p.Hrefs)
+		if p.Location != "" && !p.Hrefs[p.Location] {
+			targets = append(targets, p.Location)
+		}
+		linked := map[int]bool{}
+		for _, href := range targets {
+			to := node(canonicalURL(settings, href))
+			if linked[to] {
+				continue
+			}
+			linked[to] = true
+			g.Edges = append(g.Edges, graphEdge{from, to})
+			adjacency[from] = append(adjacency[from], to)
+		}
+	}
+
+	if root, ok := ids[g.Root]; ok {
+		g.Nodes[root].Depth = 0
+		queue := []int{root}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, next := range adjacency[cur] {
+				if g.Nodes[next].Depth < 0 {
+					g.Nodes[next].Depth = g.Nodes[cur].Depth + 1
+					queue = append(queue, next)
+				}
+			}
+		}
+	}
+	return g
+}
+
+// graph formats of 'report -graph'
+var graphFormats = []string{"dot", "graphml", "json"}
+
+func genReportGraph(settings *reportSettings, pageReports map[string]*pageReport) {
+	g := buildLinkGraph(settings, pageReports)
+
+	var err error
+	switch settings.Graph {
+	case "dot":
+		err = writeGraphDOT(settings.ReportFile+"/linkgraph.dot", g)
+	case "graphml":
+		err = writeGraphML(settings.ReportFile+"/linkgraph.graphml", g)
+	case "json":
+		err = writeGraphJSON(settings.ReportFile+"/linkgraph.json", g)
+	default:
+		err = fmt.Errorf("unknown graph format %s", settings.Graph)
+	}
+	checkError(err)
+}
+
+func writeGraphDOT(path string, g *linkGraph) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	b := &strings.Builder{}
+	b.WriteString("digraph links {\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(b, "\tn%d [label=%s, url=%s, host=%s, crawled=%t, status=%d, contenttype=%s, depth=%d];\n",
+			n.ID, dotQuote(n.URL), dotQuote(n.URL), dotQuote(n.Host),
+			n.Crawled, n.StatusCode, dotQuote(n.ContentType), n.Depth)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(b, "\tn%d -> n%d;\n", e.From, e.To)
+	}
+	b.WriteString("}\n")
+	_, err = file.WriteString(b.String())
+	return err
+}
+
+// dotQuote quotes s as a DOT string, only '"' and '\' are escaped.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+func writeGraphML(path string, g *linkGraph) error {
+	doc := graphML{XMLNS: "http://graphml.graphdrawing.org/xmlns"}
+	doc.Keys = []graphMLKey{
+		{"url", "node", "url", "string"},
+		{"host", "node", "host", "string"},
+		{"crawled", "node", "crawled", "boolean"},
+		{"status", "node", "status", "int"},
+		{"contenttype", "node", "contenttype", "string"},
+		{"depth", "node", "depth", "int"},
+	}
+	doc.Graph.EdgeDefault = "directed"
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: "n" + strconv.Itoa(n.ID),
+			Data: []graphMLData{
+				{"url", n.URL},
+				{"host", n.Host},
+				{"crawled", strconv.FormatBool(n.Crawled)},
+				{"status", strconv.Itoa(n.StatusCode)},
+				{"contenttype", n.ContentType},
+				{"depth", strconv.Itoa(n.Depth)},
+			},
+		})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			"n" + strconv.Itoa(e.From), "n" + strconv.Itoa(e.To)})
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	file.WriteString(xml.Header)
+	enc := xml.NewEncoder(file)
+	enc.Indent("", "\t")
+	return enc.Encode(doc)
+}
+
+func writeGraphJSON(path string, g *linkGraph) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "\t")
+	return enc.Encode(g)
+}
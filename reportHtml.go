@@ -0,0 +1,152 @@
+package main
+
+import (
+	"html/template"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type htmlReport struct {
+	Generated   string
+	StoragePath string
+	PageCount   int
+	Hosts       []*htmlHost
+	StatusCodes []*statusCount
+	Pages       []*htmlPage
+}
+
+// htmlPage is a page with the validation errors of invalidtags.csv, rated
+// and filtered with the html rule profile.
+type htmlPage struct {
+	*pageReport
+	Validations []*ratedValidation
+}
+
+type htmlHost struct {
+	Name  string
+	Pages int
+	Tree  *siteNode
+}
+
+// siteNode is a path segment of the site tree, Pages holds the crawled
+// urls (with different queries) ending at this segment.
+type siteNode struct {
+	Name     string
+	Pages    []*pageReport
+	Children []*siteNode
+	children map[string]*siteNode
+}
+
+type statusCount struct {
+	Code  int
+	Class string
+	Count int
+}
+
+func (n *siteNode) child(name string) *siteNode {
+	c, ok := n.children[name]
+	if !ok {
+		c = &siteNode{Name: name, children: map[string]*siteNode{}}
+		n.children[name] = c
+		n.Children = append(n.Children, c)
+	}
+	return c
+}
+
+func (n *siteNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	sort.Slice(n.Pages, func(i, j int) bool {
+		return n.Pages[i].URL < n.Pages[j].URL
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+func statusClass(code int) string {
+	if code == 0 {
+		return "error"
+	}
+	return string(rune('0'+code/100)) + "xx"
+}
+
+func buildHTMLReport(settings *reportSettings, pageReports map[string]*pageReport) *htmlReport {
+	report := &htmlReport{}
+	report.Generated = time.Now().Format(time.RFC1123)
+	report.StoragePath = settings.StoragePath
+	report.PageCount = len(pageReports)
+
+	hosts := map[string]*htmlHost{}
+	codes := map[int]*statusCount{}
+
+	for _, p := range pageReports {
+		report.Pages = append(report.Pages, &htmlPage{p, rateValidations(settings, p.InvalidTags)})
+
+		c, ok := codes[p.StatusCode]
+		if !ok {
+			c = &statusCount{Code: p.StatusCode, Class: statusClass(p.StatusCode)}
+			codes[p.StatusCode] = c
+		}
+		c.Count++
+
+		pURL, err := url.Parse(p.URL)
+		if err != nil {
+			continue
+		}
+		host, ok := hosts[pURL.Host]
+		if !ok {
+			root := &siteNode{Name: pURL.Scheme + "://" + pURL.Host, children: map[string]*siteNode{}}
+			host = &htmlHost{Name: pURL.Host, Tree: root}
+			hosts[pURL.Host] = host
+		}
+		host.Pages++
+
+		node := host.Tree
+		for _, segment := range strings.Split(strings.Trim(pURL.Path, "/"), "/") {
+			if segment == "" {
+				continue
+			}
+			node = node.child(segment)
+		}
+		node.Pages = append(node.Pages, p)
+	}
+
+	sort.Slice(report.Pages, func(i, j int) bool {
+		return report.Pages[i].URL < report.Pages[j].URL
+	})
+	for _, h := range hosts {
+		h.Tree.sort()
+		report.Hosts = append(report.Hosts, h)
+	}
+	sort.Slice(report.Hosts, func(i, j int) bool {
+		return report.Hosts[i].Name < report.Hosts[j].Name
+	})
+	for _, c := range codes {
+		report.StatusCodes = append(report.StatusCodes, c)
+	}
+	sort.Slice(report.StatusCodes, func(i, j int) bool {
+		return report.StatusCodes[i].Code < report.StatusCodes[j].Code
+	})
+	return report
+}
+
+func genReportHTML(settings *reportSettings, pageReports map[string]*pageReport) {
+	tmpl, err := template.New(filepath.Base(settings.HTMLTemplate)).Funcs(template.FuncMap{
+		"join": strings.Join,
+	}).ParseFiles(settings.HTMLTemplate)
+	checkError(err)
+
+	path := settings.ReportFile + "/report.html"
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	checkError(err)
+	defer file.Close()
+
+	err = tmpl.Execute(file, buildHTMLReport(settings, pageReports))
+	checkError(err)
+}
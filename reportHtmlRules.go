@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/BlackEspresso/htmlcheck"
+)
+
+var reasonNames = map[htmlcheck.ErrorReason]string{
+	htmlcheck.InvTag:                 "invalid-tag",
+	htmlcheck.InvAttribute:           "invalid-attribute",
+	htmlcheck.InvClosedBeforeOpened:  "closed-before-opened",
+	htmlcheck.InvNotProperlyClosed:   "not-properly-closed",
+	htmlcheck.InvDuplicatedAttribute: "duplicated-attribute",
+	htmlcheck.InvEOF:                 "eof",
+}
+
+func reasonName(reason htmlcheck.ErrorReason) string {
+	if name, ok := reasonNames[reason]; ok {
+		return name
+	}
+	return fmt.Sprint(int(reason))
+}
+
+func isReasonName(name string) bool {
+	for _, n := range reasonNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlRule sets the severity of validation errors. Reason, Tag and
+// Attribute are optional, Tag and Attribute are regular expressions.
+type htmlRule struct {
+	Reason    string
+	Tag       string
+	Attribute string
+	Severity  string
+	tag       *regexp.Regexp
+	attribute *regexp.Regexp
+}
+
+type htmlRuleProfiles struct {
+	Profiles map[string][]*htmlRule
+}
+
+type ratedValidation struct {
+	*htmlcheck.ValidationError
+	Severity string
+}
+
+func loadHTMLRules(path, profile string) ([]*htmlRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	profiles := &htmlRuleProfiles{}
+	err = json.Unmarshal(data, profiles)
+	if err != nil {
+		return nil, err
+	}
+	rules, ok := profiles.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("html rule profile %s not found in %s", profile, path)
+	}
+	for _, r := range rules {
+		if _, ok := severityOrder[r.Severity]; !ok && r.Severity != sevIgnore {
+			return nil, fmt.Errorf("invalid severity %s in profile %s", r.Severity, profile)
+		}
+		if r.Reason != "" && !isReasonName(r.Reason) {
+			return nil, fmt.Errorf("unknown reason %s in profile %s", r.Reason, profile)
+		}
+		if r.Tag != "" {
+			if r.tag, err = regexp.Compile(r.Tag); err != nil {
+				return nil, err
+			}
+		}
+		if r.Attribute != "" {
+			if r.attribute, err = regexp.Compile(r.Attribute); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rules, nil
+}
+
+// severityOf returns the severity of the first matching rule, unmatched
+// errors are info.
+func severityOf(rules []*htmlRule, v *htmlcheck.ValidationError) string {
+	for _, r := range rules {
+		if r.Reason != "" && r.Reason != reasonName(v.Reason) {
+			continue
+		}
+		if r.tag != nil && !r.tag.MatchString(v.TagName) {
+			continue
+		}
+		if r.attribute != nil && !r.attribute.MatchString(v.AttributeName) {
+			continue
+		}
+		return r.Severity
+	}
+	return sevInfo
+}
+
+// rateValidations applies the rule profile and the minimum severity to the
+// validation errors of a page and keeps at most MaxPerPage errors per reason.
+func rateValidations(settings *reportSettings, validations []*htmlcheck.ValidationError) []*ratedValidation {
+	severities := map[*htmlcheck.ValidationError]string{}
+	kept := []*htmlcheck.ValidationError{}
+	for _, v := range validations {
+		severity := severityOf(settings.HTMLRules, v)
+		if severity == sevIgnore || severityOrder[severity] > severityOrder[settings.MinSeverity] {
+			continue
+		}
+		severities[v] = severity
+		kept = append(kept, v)
+	}
+
+	rated := []*ratedValidation{}
+	for reason := range reasonNames {
+		for _, v := range filterInvalidHTMLByType(kept, reason, settings.MaxPerPage) {
+			rated = append(rated, &ratedValidation{v, severities[v]})
+		}
+	}
+	sort.Slice(rated, func(i, j int) bool {
+		return rated[i].Pos.Start < rated[j].Pos.Start
+	})
+	return rated
+}
+
+type reasonCount struct {
+	Reason   string
+	Severity string
+	Count    int
+	pages    map[string]bool
+}
+
+// validationSummary counts reported validation errors per reason and severity.
+type validationSummary map[string]*reasonCount
+
+func (s validationSummary) add(v *ratedValidation, pageURL string) {
+	reason := reasonName(v.Reason)
+	key := reason + "\x00" + v.Severity
+	c, ok := s[key]
+	if !ok {
+		c = &reasonCount{Reason: reason, Severity: v.Severity, pages: map[string]bool{}}
+		s[key] = c
+	}
+	c.Count++
+	c.pages[pageURL] = true
+}
+
+func genReportInvalidTagsSummary(settings *reportSettings, summary validationSummary) {
+	counts := []*reasonCount{}
+	for _, c := range summary {
+		counts = append(counts, c)
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Severity != counts[j].Severity {
+			return severityOrder[counts[i].Severity] < severityOrder[counts[j].Severity]
+		}
+		return counts[i].Reason < counts[j].Reason
+	})
+
+	path := settings.ReportFile + "/invalidtags_summary.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"reason", "severity", "count", "pages"})
+	for _, c := range counts {
+		csv.Write([]string{c.Reason, c.Severity, strconv.Itoa(c.Count), strconv.Itoa(len(c.pages))})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
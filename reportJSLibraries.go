@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+// jsLibraryRule is an entry of config/jsvulns.json, similar to the retire.js
+// repository. In patterns (§§version§§) is replaced by a version group.
+// Filename is matched against the file name of a script url, Uri against
+// the whole url and FileContent against stored scripts. Hashes maps the
+// sha1 of a script to its version.
+type jsLibraryRule struct {
+	Library         string
+	Filename        []string
+	Uri             []string
+	FileContent     []string
+	Hashes          map[string]string
+	Vulnerabilities []jsVulnerability
+}
+
+// jsVulnerability affects versions >= AtOrAbove and < Below, an empty
+// bound is open.
+type jsVulnerability struct {
+	AtOrAbove   string
+	Below       string
+	Severity    string
+	Identifiers []string
+	Summary     string
+}
+
+type jsLibrary struct {
+	Name            string
+	Filename        []*regexp.Regexp
+	Uri             []*regexp.Regexp
+	FileContent     []*regexp.Regexp
+	Hashes          map[string]string
+	Vulnerabilities []jsVulnerability
+}
+
+// jsLibraryMatch is a library version found in Script, by filename, uri,
+// content or hash.
+type jsLibraryMatch struct {
+	Name     string
+	Version  string
+	Script   string
+	Evidence string
+}
+
+const versionPlaceholder = "(§§version§§)"
+const versionPattern = `(?P<version>\d+(?:\.\d+)+(?:[.-]?(?:alpha|beta|rc)[.-]?\d*)?)`
+
+func loadJSLibraries(path string) ([]*jsLibrary, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*jsLibraryRule{}
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	libs := []*jsLibrary{}
+	for _, r := range rules {
+		for _, v := range r.Vulnerabilities {
+			if _, ok := severityOrder[v.Severity]; !ok {
+				return nil, fmt.Errorf("invalid severity %s for %s", v.Severity, r.Library)
+			}
+		}
+		l := &jsLibrary{Name: r.Library, Hashes: r.Hashes, Vulnerabilities: r.Vulnerabilities}
+		if l.Filename, err = compileVersionPatterns(r.Filename); err != nil {
+			return nil, err
+		}
+		if l.Uri, err = compileVersionPatterns(r.Uri); err != nil {
+			return nil, err
+		}
+		if l.FileContent, err = compileVersionPatterns(r.FileContent); err != nil {
+			return nil, err
+		}
+		libs = append(libs, l)
+	}
+	return libs, nil
+}
+
+func compileVersionPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, versionPlaceholder) {
+			return nil, fmt.Errorf("missing %s in pattern %s", versionPlaceholder, pattern)
+		}
+		pattern = strings.Replace(pattern, versionPlaceholder, versionPattern, 1)
+		regex, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, regex)
+	}
+	return compiled, nil
+}
+
+func matchVersion(patterns []*regexp.Regexp, text string) string {
+	for _, p := range patterns {
+		if m := p.FindStringSubmatch(text); m != nil {
+			return m[p.SubexpIndex("version")]
+		}
+	}
+	return ""
+}
+
+// detectJSLibraries checks the script urls of html pages and the content of
+// stored scripts.
+func detectJSLibraries(libs []*jsLibrary, page *crawlbase.Page, pr *pageReport) []jsLibraryMatch {
+	matches := []jsLibraryMatch{}
+	seen := map[jsLibraryMatch]bool{}
+	add := func(m jsLibraryMatch) {
+		key := jsLibraryMatch{Name: m.Name, Version: m.Version, Script: m.Script}
+		if m.Version == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		matches = append(matches, m)
+	}
+	byURL := func(script string) {
+		u, err := url.Parse(script)
+		if err != nil {
+			return
+		}
+		fileName := path.Base(u.Path)
+		for _, l := range libs {
+			add(jsLibraryMatch{l.Name, matchVersion(l.Filename, fileName), script, "filename"})
+			add(jsLibraryMatch{l.Name, matchVersion(l.Uri, script), script, "uri"})
+		}
+	}
+
+	for _, r := range pr.Resources {
+		if r.Kind == resScript {
+			byURL(r.URL)
+		}
+	}
+	if strings.Contains(pr.ContentType, "javascript") {
+		byURL(pr.URL)
+		sum := sha1.Sum(page.ResponseBody)
+		hash := hex.EncodeToString(sum[:])
+		body := string(page.ResponseBody)
+		for _, l := range libs {
+			add(jsLibraryMatch{l.Name, l.Hashes[hash], pr.URL, "hash"})
+			add(jsLibraryMatch{l.Name, matchVersion(l.FileContent, body), pr.URL, "content"})
+		}
+	}
+	return matches
+}
+
+var regVersionPart = regexp.MustCompile(`\d+|[a-z]+`)
+
+// compareVersions compares dotted versions, missing numeric parts are 0 and
+// pre-releases like 3.0.0-beta1 are lower than the release.
+func compareVersions(a, b string) int {
+	pa := regVersionPart.FindAllString(strings.ToLower(a), -1)
+	pb := regVersionPart.FindAllString(strings.ToLower(b), -1)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		if i >= len(pa) || i >= len(pb) {
+			rest, sign := pb, -1
+			if i < len(pa) {
+				rest, sign = pa, 1
+			}
+			n, err := strconv.Atoi(rest[i])
+			if err != nil {
+				// a trailing pre-release tag makes the longer version lower
+				return -sign
+			}
+			if n != 0 {
+				return sign
+			}
+			continue
+		}
+		na, errA := strconv.Atoi(pa[i])
+		nb, errB := strconv.Atoi(pb[i])
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			return 1
+		case errB == nil:
+			return -1
+		default:
+			if c := strings.Compare(pa[i], pb[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func (v *jsVulnerability) affects(version string) bool {
+	if v.AtOrAbove != "" && compareVersions(version, v.AtOrAbove) < 0 {
+		return false
+	}
+	return v.Below == "" || compareVersions(version, v.Below) < 0
+}
+
+type jsLibraryUsage struct {
+	Name     string
+	Version  string
+	Scripts  map[string]bool
+	Evidence map[string]bool
+	Pages    map[string]bool
+	Vulns    []jsVulnerability
+	Severity string
+}
+
+func genReportJSLibraries(settings *reportSettings, pageReports map[string]*pageReport) {
+	libs := map[string]*jsLibrary{}
+	for _, l := range settings.JSLibraries {
+		libs[l.Name] = l
+	}
+
+	// pages loading a script
+	scriptPages := map[string]map[string]bool{}
+	for _, p := range pageReports {
+		for _, r := range p.Resources {
+			if r.Kind != resScript {
+				continue
+			}
+			if scriptPages[r.URL] == nil {
+				scriptPages[r.URL] = map[string]bool{}
+			}
+			scriptPages[r.URL][p.URL] = true
+		}
+	}
+
+	usages := map[string]*jsLibraryUsage{}
+	for _, p := range pageReports {
+		for _, m := range p.JSLibraries {
+			key := m.Name + "@" + m.Version
+			u, ok := usages[key]
+			if !ok {
+				u = &jsLibraryUsage{Name: m.Name, Version: m.Version, Scripts: map[string]bool{},
+					Evidence: map[string]bool{}, Pages: map[string]bool{}}
+				usages[key] = u
+			}
+			u.Scripts[m.Script] = true
+			u.Evidence[m.Evidence] = true
+			if m.Script != p.URL {
+				u.Pages[p.URL] = true
+			}
+			for page := range scriptPages[m.Script] {
+				u.Pages[page] = true
+			}
+		}
+	}
+
+	sorted := []*jsLibraryUsage{}
+	for _, u := range usages {
+		if l, ok := libs[u.Name]; ok {
+			for _, v := range l.Vulnerabilities {
+				if !v.affects(u.Version) {
+					continue
+				}
+				u.Vulns = append(u.Vulns, v)
+				if u.Severity == "" || severityOrder[v.Severity] < severityOrder[u.Severity] {
+					u.Severity = v.Severity
+				}
+			}
+		}
+		sorted = append(sorted, u)
+	}
+	// vulnerable libraries first
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if (a.Severity == "") != (b.Severity == "") {
+			return a.Severity != ""
+		}
+		if a.Severity != b.Severity {
+			return severityOrder[a.Severity] < severityOrder[b.Severity]
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return compareVersions(a.Version, b.Version) < 0
+	})
+
+	path := settings.ReportFile + "/jslibraries.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"library", "version", "severity", "vulnerabilities", "summary",
+		"detected by", "scripts", "pages"})
+	for _, u := range sorted {
+		ids := map[string]bool{}
+		summaries := []string{}
+		for _, v := range u.Vulns {
+			for _, id := range v.Identifiers {
+				ids[id] = true
+			}
+			summaries = append(summaries, v.Summary)
+		}
+		csv.Write([]string{u.Name, u.Version, u.Severity, strings.Join(sortedKeys(ids), ","),
+			strings.Join(summaries, ", "), strings.Join(sortedKeys(u.Evidence), ","),
+			strings.Join(sortedKeys(u.Scripts), ","), strings.Join(sortedKeys(u.Pages), ",")})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"2.0", "1.99.99", 1},
+		{"3.5", "3.5.0", 0},
+		{"3.5.0", "3.5", 0},
+		{"3.5", "3.5.0.0", 0},
+		{"3.5", "3.5.1", -1},
+		{"3.5.1", "3.5", 1},
+		{"3.0.0-beta1", "3.0.0", -1},
+		{"3.0.0", "3.0.0-beta1", 1},
+		{"3.0.0-beta1", "3.0", -1},
+		{"3.0.0-alpha", "3.0.0-beta", -1},
+		{"3.0.0-rc1", "3.0.0-rc2", -1},
+		{"3.0.0-RC1", "3.0.0-rc1", 0},
+		{"3.0.0.1", "3.0.0-rc1", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if got := compareVersions(tt.a, tt.b); got != tt.want {
+				t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVulnerabilityAffects(t *testing.T) {
+	tests := []struct {
+		name    string
+		vuln    jsVulnerability
+		version string
+		want    bool
+	}{
+		{"below", jsVulnerability{Below: "3.5.0"}, "3.4.1", true},
+		{"at upper bound", jsVulnerability{Below: "3.5.0"}, "3.5.0", false},
+		{"short version at upper bound", jsVulnerability{Below: "3.5.0"}, "3.5", false},
+		{"pre-release of upper bound", jsVulnerability{Below: "3.5.0"}, "3.5.0-rc1", true},
+		{"above", jsVulnerability{Below: "3.5.0"}, "3.6.0", false},
+		{"at lower bound", jsVulnerability{AtOrAbove: "1.2.0", Below: "3.5.0"}, "1.2", true},
+		{"below lower bound", jsVulnerability{AtOrAbove: "1.2.0", Below: "3.5.0"}, "1.1.9", false},
+		{"open upper bound", jsVulnerability{AtOrAbove: "1.2.21"}, "1.8.3", true},
+		{"open upper bound below", jsVulnerability{AtOrAbove: "1.2.21"}, "1.2.20", false},
+		{"open bounds", jsVulnerability{}, "0.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.vuln.affects(tt.version); got != tt.want {
+				t.Errorf("affects(%q) = %t, want %t", tt.version, got, tt.want)
+			}
+		})
+	}
+}
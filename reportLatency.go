@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var regNumericSegment = regexp.MustCompile(`^-?\d+$`)
+var regUUIDSegment = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+var regHexSegment = regexp.MustCompile(`^(?i)[0-9a-f]{16,}$`)
+
+// endpointPattern collapses numeric, uuid and long hex path segments of an
+// url without query, e.g. https://host/users/{int}/orders/{uuid}.
+func endpointPattern(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		switch {
+		case regNumericSegment.MatchString(s):
+			segments[i] = "{int}"
+		case regUUIDSegment.MatchString(s):
+			segments[i] = "{uuid}"
+		case regHexSegment.MatchString(s):
+			segments[i] = "{hex}"
+		}
+	}
+	return u.Scheme + "://" + u.Host + strings.Join(segments, "/")
+}
+
+type endpointStats struct {
+	Pattern   string
+	Durations []int
+	Errors    int
+	Statuses  map[int]int
+}
+
+// percentile returns the nearest rank percentile of sorted values.
+func percentile(sorted []int, p float64) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func statusDistribution(statuses map[int]int) string {
+	codes := []int{}
+	for c := range statuses {
+		codes = append(codes, c)
+	}
+	sort.Ints(codes)
+	parts := []string{}
+	for _, c := range codes {
+		parts = append(parts, fmt.Sprintf("%d:%d", c, statuses[c]))
+	}
+	return strings.Join(parts, " ")
+}
+
+func genReportLatency(settings *reportSettings, pageReports map[string]*pageReport) {
+	patterns := map[string]*endpointStats{}
+	for _, p := range pageReports {
+		pattern := endpointPattern(p.URL)
+		stats, ok := patterns[pattern]
+		if !ok {
+			stats = &endpointStats{Pattern: pattern, Statuses: map[int]int{}}
+			patterns[pattern] = stats
+		}
+		stats.Durations = append(stats.Durations, p.RespDuration)
+		stats.Statuses[p.StatusCode]++
+		if isBrokenPage(p) {
+			stats.Errors++
+		}
+	}
+
+	sorted := []*endpointStats{}
+	for _, stats := range patterns {
+		sort.Ints(stats.Durations)
+		sorted = append(sorted, stats)
+	}
+	// slowest first
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := percentile(sorted[i].Durations, 0.95), percentile(sorted[j].Durations, 0.95)
+		if pi != pj {
+			return pi > pj
+		}
+		return sorted[i].Pattern < sorted[j].Pattern
+	})
+
+	path := settings.ReportFile + "/latency.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"endpoint pattern", "requests", "errors", "error rate",
+		"min (ms)", "median (ms)", "p95 (ms)", "max (ms)", "status codes"})
+	for _, s := range sorted {
+		d := s.Durations
+		rate := float64(s.Errors) / float64(len(d))
+		csv.Write([]string{s.Pattern, strconv.Itoa(len(d)), strconv.Itoa(s.Errors),
+			strconv.FormatFloat(rate, 'f', 2, 64), strconv.Itoa(d[0]),
+			strconv.Itoa(percentile(d, 0.5)), strconv.Itoa(percentile(d, 0.95)),
+			strconv.Itoa(d[len(d)-1]), statusDistribution(s.Statuses)})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
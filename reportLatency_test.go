@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestEndpointPattern(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/", "http://example.com/"},
+		{"http://example.com/users", "http://example.com/users"},
+		{"http://example.com/users/42", "http://example.com/users/{int}"},
+		{"http://example.com/users/-1/orders/7", "http://example.com/users/{int}/orders/{int}"},
+		{"http://example.com/users/42?tab=1#top", "http://example.com/users/{int}"},
+		{"http://example.com/o/123e4567-e89b-12d3-a456-426614174000", "http://example.com/o/{uuid}"},
+		{"http://example.com/o/123E4567-E89B-12D3-A456-426614174000", "http://example.com/o/{uuid}"},
+		{"http://example.com/c/0123456789abcdef", "http://example.com/c/{hex}"},
+		{"http://example.com/c/0123456789abcde", "http://example.com/c/0123456789abcde"},
+		{"http://example.com/v2/page1", "http://example.com/v2/page1"},
+		{"http://example.com/42.json", "http://example.com/42.json"},
+		{"http://example.com:8080/a/1/", "http://example.com:8080/a/{int}/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := endpointPattern(tt.url); got != tt.want {
+				t.Errorf("endpointPattern(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	tests := []struct {
+		values []int
+		p      float64
+		want   int
+	}{
+		{nil, 0.5, 0},
+		{[]int{7}, 0.99, 7},
+		{sorted, 0, 10},
+		{sorted, 0.5, 50},
+		{sorted, 0.9, 90},
+		{sorted, 0.95, 100},
+		{sorted, 1, 100},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.values, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %v) = %d, want %d", tt.values, tt.p, got, tt.want)
+		}
+	}
+}
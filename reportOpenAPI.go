@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonSchema is inferred from json samples. Count is the number of object
+// samples, Seen how often each property was present in them.
+type jsonSchema struct {
+	Types      map[string]bool
+	Properties map[string]*jsonSchema `json:",omitempty"`
+	Seen       map[string]int         `json:",omitempty"`
+	Count      int                    `json:",omitempty"`
+	Items      *jsonSchema            `json:",omitempty"`
+}
+
+func newJSONSchema() *jsonSchema {
+	return &jsonSchema{Types: map[string]bool{}}
+}
+
+// inferJSONSchema returns the schema of a json document, nil if body is no
+// valid json.
+func inferJSONSchema(body []byte) *jsonSchema {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	s := newJSONSchema()
+	s.add(doc)
+	return s
+}
+
+func (s *jsonSchema) add(v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		s.Types["null"] = true
+	case bool:
+		s.Types["boolean"] = true
+	case float64:
+		if val == float64(int64(val)) {
+			s.Types["integer"] = true
+		} else {
+			s.Types["number"] = true
+		}
+	case string:
+		s.Types["string"] = true
+	case []interface{}:
+		s.Types["array"] = true
+		if s.Items == nil {
+			s.Items = newJSONSchema()
+		}
+		for _, item := range val {
+			s.Items.add(item)
+		}
+	case map[string]interface{}:
+		s.Types["object"] = true
+		if s.Properties == nil {
+			s.Properties = map[string]*jsonSchema{}
+			s.Seen = map[string]int{}
+		}
+		s.Count++
+		for k, child := range val {
+			p, ok := s.Properties[k]
+			if !ok {
+				p = newJSONSchema()
+				s.Properties[k] = p
+			}
+			p.add(child)
+			s.Seen[k]++
+		}
+	}
+}
+
+// merge adds the samples of other to s.
+func (s *jsonSchema) merge(other *jsonSchema) {
+	if other == nil {
+		return
+	}
+	for t := range other.Types {
+		s.Types[t] = true
+	}
+	if other.Items != nil {
+		if s.Items == nil {
+			s.Items = newJSONSchema()
+		}
+		s.Items.merge(other.Items)
+	}
+	if other.Properties != nil {
+		if s.Properties == nil {
+			s.Properties = map[string]*jsonSchema{}
+			s.Seen = map[string]int{}
+		}
+		for k, p := range other.Properties {
+			if s.Properties[k] == nil {
+				s.Properties[k] = newJSONSchema()
+			}
+			s.Properties[k].merge(p)
+			s.Seen[k] += other.Seen[k]
+		}
+	}
+	s.Count += other.Count
+}
+
+// toOpenAPI converts the schema to an OpenAPI 3.0 schema object, properties
+// missing in some samples are optional.
+func (s *jsonSchema) toOpenAPI() map[string]interface{} {
+	types := []string{}
+	nullable := false
+	for t := range s.Types {
+		if t == "null" {
+			nullable = true
+			continue
+		}
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	// integer samples of a number field
+	if len(types) == 2 && types[0] == "integer" && types[1] == "number" {
+		types = []string{"number"}
+	}
+
+	single := func(t string) map[string]interface{} {
+		o := map[string]interface{}{"type": t}
+		switch t {
+		case "array":
+			if s.Items != nil && len(s.Items.Types) > 0 {
+				o["items"] = s.Items.toOpenAPI()
+			} else {
+				o["items"] = map[string]interface{}{}
+			}
+		case "object":
+			props := map[string]interface{}{}
+			required := []string{}
+			for k, p := range s.Properties {
+				props[k] = p.toOpenAPI()
+				if s.Seen[k] == s.Count {
+					required = append(required, k)
+				}
+			}
+			sort.Strings(required)
+			o["properties"] = props
+			if len(required) > 0 {
+				o["required"] = required
+			}
+		}
+		return o
+	}
+
+	var o map[string]interface{}
+	switch len(types) {
+	case 0:
+		o = map[string]interface{}{}
+	case 1:
+		o = single(types[0])
+	default:
+		oneOf := []interface{}{}
+		for _, t := range types {
+			oneOf = append(oneOf, single(t))
+		}
+		o = map[string]interface{}{"oneOf": oneOf}
+	}
+	if nullable {
+		o["nullable"] = true
+	}
+	return o
+}
+
+var patternParamTypes = map[string]map[string]interface{}{
+	"{int}":  {"type": "integer"},
+	"{uuid}": {"type": "string", "format": "uuid"},
+	"{hex}":  {"type": "string"},
+}
+
+// openAPIPath converts the path of an endpoint pattern to an OpenAPI path
+// with named path parameters.
+func openAPIPath(patternPath string) (string, []interface{}) {
+	segments := strings.Split(patternPath, "/")
+	params := []interface{}{}
+	for i, seg := range segments {
+		schema, ok := patternParamTypes[seg]
+		if !ok {
+			continue
+		}
+		name := "param" + strconv.Itoa(len(params)+1)
+		segments[i] = "{" + name + "}"
+		params = append(params, map[string]interface{}{
+			"name": name, "in": "path", "required": true, "schema": schema,
+		})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// apiOperation is a method of an endpoint. Crawled json responses are
+// stored without method and are get operations, Form holds the inputs of
+// forms submitting to the endpoint.
+type apiOperation struct {
+	Query     map[string]bool
+	Form      map[string]bool
+	Responses map[int]*jsonSchema
+	Mimes     map[int]string
+}
+
+// apiPaths maps the OpenAPI paths of a server to their operations by method.
+type apiPaths map[string]map[string]*apiOperation
+
+func genReportOpenAPI(settings *reportSettings, pageReports map[string]*pageReport) {
+	servers := map[string]apiPaths{}
+	// operation returns the operation of rawURL, new operations are only
+	// added to known paths unless create is set
+	operation := func(rawURL, method string, create bool) *apiOperation {
+		pURL, err := url.Parse(endpointPattern(rawURL))
+		if err != nil || pURL.Host == "" {
+			return nil
+		}
+		server := pURL.Scheme + "://" + pURL.Host
+		paths := servers[server]
+		if paths == nil {
+			if !create {
+				return nil
+			}
+			paths = apiPaths{}
+			servers[server] = paths
+		}
+		methods := paths[pURL.Path]
+		if methods == nil {
+			if !create {
+				return nil
+			}
+			methods = map[string]*apiOperation{}
+			paths[pURL.Path] = methods
+		}
+		op, ok := methods[method]
+		if !ok {
+			op = &apiOperation{Query: map[string]bool{}, Form: map[string]bool{},
+				Responses: map[int]*jsonSchema{}, Mimes: map[int]string{}}
+			methods[method] = op
+		}
+		return op
+	}
+
+	for _, p := range pageReports {
+		if p.JSONSchema == nil {
+			continue
+		}
+		op := operation(p.URL, "get", true)
+		if op == nil {
+			continue
+		}
+		for k := range p.QueryKeys {
+			op.Query[k] = true
+		}
+		if op.Responses[p.StatusCode] == nil {
+			op.Responses[p.StatusCode] = newJSONSchema()
+			op.Mimes[p.StatusCode] = p.ContentType
+		}
+		op.Responses[p.StatusCode].merge(p.JSONSchema)
+	}
+	for _, p := range pageReports {
+		for _, form := range p.Forms {
+			method := strings.ToLower(form.Method)
+			if method == "" {
+				method = "get"
+			}
+			action := form.Url
+			if action == "" {
+				action = p.URL
+			}
+			op := operation(action, method, false)
+			if op == nil {
+				continue
+			}
+			for _, input := range form.Inputs {
+				if input.Name == "" {
+					continue
+				}
+				if method == "get" {
+					op.Query[input.Name] = true
+				} else {
+					op.Form[input.Name] = true
+				}
+			}
+		}
+	}
+
+	folder := settings.ReportFile + "/openapi"
+	err := os.MkdirAll(folder, 0777)
+	checkError(err)
+	for server, paths := range servers {
+		doc := openAPIDocument(settings, server, paths)
+		err := writeOpenAPI(folder+"/"+toFileName(server)+".json", doc)
+		logError(err)
+	}
+}
+
+// openAPIDocument builds the OpenAPI document of one server.
+func openAPIDocument(settings *reportSettings, server string, paths apiPaths) map[string]interface{} {
+	docPaths := map[string]interface{}{}
+	for patternPath, methods := range paths {
+		path, pathParams := openAPIPath(patternPath)
+		item := map[string]interface{}{}
+		for method, op := range methods {
+			params := append([]interface{}{}, pathParams...)
+			for _, q := range sortedKeys(op.Query) {
+				params = append(params, map[string]interface{}{
+					"name": q, "in": "query", "required": false, "schema": map[string]interface{}{"type": "string"},
+				})
+			}
+			responses := map[string]interface{}{}
+			for code, schema := range op.Responses {
+				responses[strconv.Itoa(code)] = map[string]interface{}{
+					"description": "observed response",
+					"content": map[string]interface{}{
+						op.Mimes[code]: map[string]interface{}{"schema": schema.toOpenAPI()},
+					},
+				}
+			}
+			if len(responses) == 0 {
+				responses["default"] = map[string]interface{}{"description": "not observed"}
+			}
+			o := map[string]interface{}{"responses": responses}
+			if len(params) > 0 {
+				o["parameters"] = params
+			}
+			if len(op.Form) > 0 {
+				props := map[string]interface{}{}
+				for name := range op.Form {
+					props[name] = map[string]interface{}{"type": "string"}
+				}
+				o["requestBody"] = map[string]interface{}{
+					"content": map[string]interface{}{
+						"application/x-www-form-urlencoded": map[string]interface{}{
+							"schema": map[string]interface{}{"type": "object", "properties": props},
+						},
+					},
+				}
+			}
+			item[method] = o
+		}
+		docPaths[path] = item
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Inferred API " + server,
+			"version":     "0.0.0",
+			"description": "inferred from crawled json responses of " + settings.StoragePath,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": server}},
+		"paths":   docPaths,
+	}
+}
+
+func writeOpenAPI(path string, doc map[string]interface{}) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInferJSONSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []string
+		want    string
+	}{
+		{"invalid json", []string{`{"a":`}, ``},
+		{"string", []string{`"a"`}, `{"type":"string"}`},
+		{"integer", []string{`1`}, `{"type":"integer"}`},
+		{"number", []string{`1.5`}, `{"type":"number"}`},
+		{"integer and number", []string{`1`, `1.5`}, `{"type":"number"}`},
+		{"boolean", []string{`true`}, `{"type":"boolean"}`},
+		{"null", []string{`null`}, `{"nullable":true}`},
+		{"nullable string", []string{`"a"`, `null`}, `{"nullable":true,"type":"string"}`},
+		{"mixed types", []string{`"a"`, `true`}, `{"oneOf":[{"type":"boolean"},{"type":"string"}]}`},
+		{"empty array", []string{`[]`}, `{"items":{},"type":"array"}`},
+		{"array", []string{`[1, 2]`}, `{"items":{"type":"integer"},"type":"array"}`},
+		{
+			"object",
+			[]string{`{"id": 1, "name": "a"}`},
+			`{"properties":{"id":{"type":"integer"},"name":{"type":"string"}},"required":["id","name"],"type":"object"}`,
+		},
+		{
+			"optional property",
+			[]string{`{"id": 1, "name": "a"}`, `{"id": 2}`},
+			`{"properties":{"id":{"type":"integer"},"name":{"type":"string"}},"required":["id"],"type":"object"}`,
+		},
+		{
+			"optional in array items",
+			[]string{`[{"id": 1, "tag": "x"}, {"id": 2}]`},
+			`{"items":{"properties":{"id":{"type":"integer"},"tag":{"type":"string"}},"required":["id"],"type":"object"},"type":"array"}`,
+		},
+		{
+			"nested object",
+			[]string{`{"user": {"id": 1}}`, `{"user": null}`},
+			`{"properties":{"user":{"nullable":true,"properties":{"id":{"type":"integer"}},"required":["id"],"type":"object"}},"required":["user"],"type":"object"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var schema *jsonSchema
+			for _, sample := range tt.samples {
+				s := inferJSONSchema([]byte(sample))
+				if s == nil {
+					continue
+				}
+				if schema == nil {
+					schema = newJSONSchema()
+				}
+				schema.merge(s)
+			}
+			got := ""
+			if schema != nil {
+				data, err := json.Marshal(schema.toOpenAPI())
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = string(data)
+			}
+			if got != tt.want {
+				t.Errorf("schema of %v =\n%s\nwant\n%s", tt.samples, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		params  int
+	}{
+		{"/users", "/users", 0},
+		{"/users/{int}", "/users/{param1}", 1},
+		{"/users/{int}/orders/{uuid}", "/users/{param1}/orders/{param2}", 2},
+		{"/c/{hex}/", "/c/{param1}/", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, params := openAPIPath(tt.pattern)
+			if got != tt.want || len(params) != tt.params {
+				t.Errorf("openAPIPath(%q) = %q with %d params, want %q with %d",
+					tt.pattern, got, len(params), tt.want, tt.params)
+			}
+		})
+	}
+}
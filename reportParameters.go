@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+// parameter sources
+const (
+	paramQuery  = "query"
+	paramForm   = "form"
+	paramJSON   = "json"
+	paramCookie = "cookie"
+	paramJS     = "js"
+)
+
+// origins of parameters which are not sent with a request, used instead
+// of the method. Stored pages have no method, the crawler only sends GET.
+const (
+	originResponse  = "response"
+	originSetCookie = "Set-Cookie"
+	originCookie    = "Cookie"
+	originScript    = "script"
+)
+
+var regJSQueryParam = regexp.MustCompile(`[?&]([A-Za-z_][\w\-\[\]]{0,40})=`)
+var regJSParamCall = regexp.MustCompile(`\.(?:get|set|append|has)\(\s*['"]([A-Za-z_][\w\-\[\]]{0,40})['"]`)
+
+type paramUse struct {
+	Method   string
+	Endpoint string
+}
+
+// paramInfo is a parameter of the inventory with all endpoints it was
+// seen on and an example value per endpoint.
+type paramInfo struct {
+	Name   string
+	Source string
+	Uses   map[paramUse]string
+}
+
+type paramInventory map[string]*paramInfo
+
+func (inv paramInventory) add(name, source, method, endpoint, value string) {
+	if name == "" {
+		return
+	}
+	key := source + "\x00" + name
+	info, ok := inv[key]
+	if !ok {
+		info = &paramInfo{Name: name, Source: source, Uses: map[paramUse]string{}}
+		inv[key] = info
+	}
+	use := paramUse{Method: method, Endpoint: endpoint}
+	if _, ok := info.Uses[use]; !ok || value != "" {
+		info.Uses[use] = value
+	}
+}
+
+// toEndpoint strips query and fragment from rawURL.
+func toEndpoint(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	return u.String()
+}
+
+// getJSONKeys returns the keys of a json document as paths, e.g. user.id or
+// items[].name.
+func getJSONKeys(body []byte) map[string]bool {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil
+	}
+	keys := map[string]bool{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			for k, child := range val {
+				key := k
+				if prefix != "" {
+					key = prefix + "." + k
+				}
+				keys[key] = true
+				walk(key, child)
+			}
+		case []interface{}:
+			for _, child := range val {
+				walk(prefix+"[]", child)
+			}
+		}
+	}
+	walk("", doc)
+	return keys
+}
+
+// getCookieNames returns the cookies set by the response and the cookies
+// sent with the request.
+func getCookieNames(page *crawlbase.Page) (map[string]string, map[string]string) {
+	set := map[string]string{}
+	if page.Response != nil {
+		resp := http.Response{Header: page.Response.Header}
+		for _, c := range resp.Cookies() {
+			set[c.Name] = c.Value
+		}
+	}
+	sent := map[string]string{}
+	if page.Request != nil {
+		req := http.Request{Header: page.Request.Header}
+		for _, c := range req.Cookies() {
+			sent[c.Name] = c.Value
+		}
+	}
+	return set, sent
+}
+
+var regInlineScript = regexp.MustCompile(`(?is)<script[^>]*>(.*?)</script>`)
+
+// getScriptParams finds parameter names in javascript, e.g. "?id=" or
+// params.get("id"). For html pages only inline scripts are searched.
+func getScriptParams(body []byte, mime string) map[string]bool {
+	if mime == "text/html" {
+		scripts := [][]byte{}
+		for _, m := range regInlineScript.FindAllSubmatch(body, -1) {
+			scripts = append(scripts, m[1])
+		}
+		body = bytes.Join(scripts, []byte("\n"))
+	}
+
+	params := map[string]bool{}
+	for _, m := range regJSQueryParam.FindAllSubmatch(body, 500) {
+		params[string(m[1])] = true
+	}
+	for _, m := range regJSParamCall.FindAllSubmatch(body, 500) {
+		params[string(m[1])] = true
+	}
+	return params
+}
+
+func buildParamInventory(pageReports map[string]*pageReport) paramInventory {
+	inv := paramInventory{}
+	for _, p := range pageReports {
+		endpoint := toEndpoint(p.URL)
+		if pURL, err := url.Parse(p.URL); err == nil {
+			for name, values := range pURL.Query() {
+				inv.add(name, paramQuery, "GET", endpoint, values[0])
+			}
+		}
+		for _, form := range p.Forms {
+			method := strings.ToUpper(form.Method)
+			if method == "" {
+				method = "GET"
+			}
+			action := form.Url
+			if action == "" {
+				action = p.URL
+			}
+			for _, input := range form.Inputs {
+				inv.add(input.Name, paramForm, method, toEndpoint(action), input.Value)
+			}
+		}
+		for key := range p.JSONKeys {
+			inv.add(key, paramJSON, originResponse, endpoint, "")
+		}
+		for name, value := range p.Cookies {
+			inv.add(name, paramCookie, originSetCookie, endpoint, value)
+		}
+		for name, value := range p.RequestCookies {
+			inv.add(name, paramCookie, originCookie, endpoint, value)
+		}
+		for name := range p.ScriptParams {
+			inv.add(name, paramJS, originScript, endpoint, "")
+		}
+	}
+	return inv
+}
+
+func sortedParams(inv paramInventory) []*paramInfo {
+	params := []*paramInfo{}
+	for _, info := range inv {
+		params = append(params, info)
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i].Name != params[j].Name {
+			return params[i].Name < params[j].Name
+		}
+		return params[i].Source < params[j].Source
+	})
+	return params
+}
+
+func sortedUses(info *paramInfo) []paramUse {
+	uses := []paramUse{}
+	for use := range info.Uses {
+		uses = append(uses, use)
+	}
+	sort.Slice(uses, func(i, j int) bool {
+		if uses[i].Endpoint != uses[j].Endpoint {
+			return uses[i].Endpoint < uses[j].Endpoint
+		}
+		return uses[i].Method < uses[j].Method
+	})
+	return uses
+}
+
+func genReportParameters(settings *reportSettings, pageReports map[string]*pageReport) {
+	path := settings.ReportFile + "/parameters.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"name", "source", "endpoints", "method or origin", "endpoint", "example value"})
+
+	inv := buildParamInventory(pageReports)
+	for _, info := range sortedParams(inv) {
+		count := strconv.Itoa(len(info.Uses))
+		for _, use := range sortedUses(info) {
+			csv.Write([]string{info.Name, info.Source, count, use.Method,
+				use.Endpoint, info.Uses[use]})
+		}
+	}
+	csv.Flush()
+	checkError(csv.Error())
+
+	if settings.ParamRequests {
+		writeParamRequests(settings, inv)
+	}
+}
+
+// writeParamRequests writes one raw http request per endpoint and method
+// with all query, form and cookie parameters to reportsfolder/requests.
+// The files can be used with 'httpscan -input' and 'httppipe -input',
+// httpscan fuzzes form parameters in the body.
+func writeParamRequests(settings *reportSettings, inv paramInventory) {
+	folder := settings.ReportFile + "/requests"
+	err := os.MkdirAll(folder, 0777)
+	checkError(err)
+
+	requests := map[paramUse]url.Values{}
+	hostCookies := map[string]map[string]string{}
+
+	for _, info := range inv {
+		for use, value := range info.Uses {
+			if info.Source == paramCookie {
+				u, err := url.Parse(use.Endpoint)
+				if err != nil {
+					continue
+				}
+				if hostCookies[u.Host] == nil {
+					hostCookies[u.Host] = map[string]string{}
+				}
+				hostCookies[u.Host][info.Name] = value
+				continue
+			}
+			if info.Source != paramQuery && info.Source != paramForm {
+				continue
+			}
+			values, ok := requests[use]
+			if !ok {
+				values = url.Values{}
+				requests[use] = values
+			}
+			if value == "" {
+				value = "1"
+			}
+			values.Set(info.Name, value)
+		}
+	}
+
+	uses := []paramUse{}
+	for use := range requests {
+		uses = append(uses, use)
+	}
+	sort.Slice(uses, func(i, j int) bool {
+		if uses[i].Endpoint != uses[j].Endpoint {
+			return uses[i].Endpoint < uses[j].Endpoint
+		}
+		return uses[i].Method < uses[j].Method
+	})
+
+	for i, use := range uses {
+		values := requests[use]
+		var req *http.Request
+		if use.Method == "GET" {
+			req, err = http.NewRequest("GET", use.Endpoint+"?"+values.Encode(), nil)
+		} else {
+			req, err = http.NewRequest(use.Method, use.Endpoint,
+				strings.NewReader(values.Encode()))
+			if err == nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+		}
+		if err != nil {
+			logError(err)
+			continue
+		}
+		cookies := hostCookies[req.URL.Host]
+		names := []string{}
+		for name := range cookies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			req.AddCookie(&http.Cookie{Name: name, Value: cookies[name]})
+		}
+
+		fileName := strconv.Itoa(i) + "_" + use.Method + "_" + toFileName(req.URL.Host+req.URL.Path) + ".req"
+		err = writeHttpRequestToFile(path.Join(folder, fileName), req)
+		logError(err)
+	}
+}
+
+var regUnsafeFileChars = regexp.MustCompile(`[^\w.-]+`)
+
+func toFileName(text string) string {
+	name := regUnsafeFileChars.ReplaceAllString(text, "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// redirectChain is a sequence of redirects starting at a page which is not
+// the target of another redirect. Candidates are query parameters whose
+// value appears in a Location header of the chain.
+type redirectChain struct {
+	URLs       []string
+	FinalCode  int
+	Loop       bool
+	CrossHost  bool
+	Candidates []redirectCandidate
+}
+
+type redirectCandidate struct {
+	URL   string
+	Param string
+}
+
+func (c *redirectChain) Start() string {
+	return c.URLs[0]
+}
+
+func (c *redirectChain) Final() string {
+	return c.URLs[len(c.URLs)-1]
+}
+
+// redirectParams returns the query parameters of pageURL whose value is
+// reflected in location.
+func redirectParams(pageURL, location string) []string {
+	pURL, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	decoded, err := url.QueryUnescape(location)
+	if err != nil {
+		decoded = location
+	}
+	params := []string{}
+	for name, values := range pURL.Query() {
+		for _, v := range values {
+			if len(v) >= 4 && (strings.Contains(location, v) || strings.Contains(decoded, v)) {
+				params = append(params, name)
+				break
+			}
+		}
+	}
+	sort.Strings(params)
+	return params
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func buildRedirectChains(pageReports map[string]*pageReport) []*redirectChain {
+	targets := map[string]bool{}
+	for _, p := range pageReports {
+		if p.Location != "" {
+			targets[p.Location] = true
+		}
+	}
+
+	urls := []string{}
+	for u, p := range pageReports {
+		if p.Location != "" {
+			urls = append(urls, u)
+		}
+	}
+	sort.Strings(urls)
+
+	covered := map[string]bool{}
+	chains := []*redirectChain{}
+	follow := func(start string) {
+		chain := &redirectChain{}
+		visited := map[string]bool{}
+		candidates := map[redirectCandidate]bool{}
+		cur := start
+		for {
+			chain.URLs = append(chain.URLs, cur)
+			visited[cur] = true
+			covered[cur] = true
+			p, ok := pageReports[cur]
+			if !ok {
+				break
+			}
+			chain.FinalCode = p.StatusCode
+			if p.Location == "" {
+				break
+			}
+			if hostOf(p.Location) != hostOf(cur) {
+				chain.CrossHost = true
+			}
+			for _, param := range redirectParams(cur, p.Location) {
+				candidates[redirectCandidate{cur, param}] = true
+			}
+			if visited[p.Location] {
+				chain.URLs = append(chain.URLs, p.Location)
+				chain.Loop = true
+				break
+			}
+			cur = p.Location
+		}
+		for c := range candidates {
+			chain.Candidates = append(chain.Candidates, c)
+		}
+		sort.Slice(chain.Candidates, func(i, j int) bool {
+			a, b := chain.Candidates[i], chain.Candidates[j]
+			return a.URL+" "+a.Param < b.URL+" "+b.Param
+		})
+		chains = append(chains, chain)
+	}
+
+	for _, u := range urls {
+		if !targets[u] {
+			follow(u)
+		}
+	}
+	// redirects not reached from a chain start are part of a loop
+	for _, u := range urls {
+		if !covered[u] {
+			follow(u)
+		}
+	}
+	return chains
+}
+
+func genReportRedirects(settings *reportSettings, pageReports map[string]*pageReport) {
+	chains := buildRedirectChains(pageReports)
+
+	path := settings.ReportFile + "/redirects.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"start url", "hops", "final url", "final Http code", "loop",
+		"cross host", "open redirect candidates", "chain"})
+	for _, c := range chains {
+		finalCode := strconv.Itoa(c.FinalCode)
+		if _, crawled := pageReports[c.Final()]; !crawled || c.Loop {
+			finalCode = ""
+		}
+		candidates := []string{}
+		for _, cand := range c.Candidates {
+			candidates = append(candidates, cand.Param+" ("+cand.URL+")")
+		}
+		csv.Write([]string{c.Start(), strconv.Itoa(len(c.URLs) - 1), c.Final(), finalCode,
+			strconv.FormatBool(c.Loop), strconv.FormatBool(c.CrossHost),
+			strings.Join(candidates, ","), strings.Join(c.URLs, " -> ")})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+
+	if settings.RedirectRequests {
+		writeOpenRedirectRequests(settings, chains)
+	}
+}
+
+// writeOpenRedirectRequests writes a raw request per open redirect
+// candidate to reportsfolder/openredirects, with only the candidate query
+// parameter, to verify with
+// 'httpscan -input <file> -vectors ./config/redirectvectors.json'.
+func writeOpenRedirectRequests(settings *reportSettings, chains []*redirectChain) {
+	candidates := map[redirectCandidate]bool{}
+	for _, c := range chains {
+		for _, candidate := range c.Candidates {
+			candidates[candidate] = true
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	sorted := []redirectCandidate{}
+	for c := range candidates {
+		sorted = append(sorted, c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		return a.URL+" "+a.Param < b.URL+" "+b.Param
+	})
+
+	folder := settings.ReportFile + "/openredirects"
+	err := os.MkdirAll(folder, 0777)
+	checkError(err)
+
+	for i, c := range sorted {
+		req, err := http.NewRequest("GET", c.URL, nil)
+		if err != nil {
+			logError(err)
+			continue
+		}
+		query := url.Values{}
+		query[c.Param] = req.URL.Query()[c.Param]
+		req.URL.RawQuery = query.Encode()
+		fileName := strconv.Itoa(i) + "_GET_" + toFileName(req.URL.Host+req.URL.Path+"_"+c.Param) + ".req"
+		err = writeHttpRequestToFile(path.Join(folder, fileName), req)
+		logError(err)
+	}
+}
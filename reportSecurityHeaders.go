@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+type headerFinding struct {
+	Check    string
+	Severity string
+	Finding  string
+}
+
+// hostFinding aggregates a finding over all responses of a host.
+type hostFinding struct {
+	Host string
+	headerFinding
+	Count      int
+	ExampleURL string
+}
+
+// auditHeaders checks the security headers and cookie flags of a response.
+func auditHeaders(pageURL *url.URL, header http.Header, mime string) []headerFinding {
+	findings := []headerFinding{}
+	add := func(check, severity, finding string) {
+		findings = append(findings, headerFinding{check, severity, finding})
+	}
+	isHTTPS := pageURL.Scheme == "https"
+	isHTML := mime == "text/html"
+
+	if isHTML {
+		csp := header.Get("Content-Security-Policy")
+		if csp == "" {
+			if header.Get("Content-Security-Policy-Report-Only") != "" {
+				add("csp", sevLow, "only Content-Security-Policy-Report-Only set")
+			} else {
+				add("csp", sevMedium, "missing Content-Security-Policy")
+			}
+		} else {
+			for _, f := range auditCSP(csp) {
+				add("csp", f.Severity, f.Finding)
+			}
+		}
+
+		xfo := strings.ToUpper(strings.TrimSpace(header.Get("X-Frame-Options")))
+		if !strings.Contains(csp, "frame-ancestors") {
+			if xfo == "" {
+				add("framing", sevMedium, "missing X-Frame-Options and CSP frame-ancestors")
+			} else if xfo != "DENY" && xfo != "SAMEORIGIN" {
+				add("framing", sevLow, "invalid X-Frame-Options "+xfo)
+			}
+		}
+
+		if header.Get("Referrer-Policy") == "" {
+			add("referrer-policy", sevLow, "missing Referrer-Policy")
+		} else if policy := strings.ToLower(header.Get("Referrer-Policy")); strings.Contains(policy, "unsafe-url") {
+			add("referrer-policy", sevLow, "Referrer-Policy unsafe-url leaks full urls")
+		}
+
+		if header.Get("Permissions-Policy") == "" {
+			add("permissions-policy", sevInfo, "missing Permissions-Policy")
+		}
+	}
+
+	if strings.ToLower(strings.TrimSpace(header.Get("X-Content-Type-Options"))) != "nosniff" {
+		add("x-content-type-options", sevLow, "missing X-Content-Type-Options: nosniff")
+	}
+
+	if isHTTPS {
+		hsts := header.Get("Strict-Transport-Security")
+		if hsts == "" {
+			add("hsts", sevMedium, "missing Strict-Transport-Security")
+		} else {
+			for _, f := range auditHSTS(hsts) {
+				add("hsts", f.Severity, f.Finding)
+			}
+		}
+	}
+
+	acao := strings.TrimSpace(header.Get("Access-Control-Allow-Origin"))
+	acac := strings.EqualFold(strings.TrimSpace(header.Get("Access-Control-Allow-Credentials")), "true")
+	switch {
+	case acao == "*" && acac:
+		add("cors", sevHigh, "Access-Control-Allow-Origin * with credentials")
+	case acao == "*":
+		add("cors", sevInfo, "Access-Control-Allow-Origin *")
+	case strings.EqualFold(acao, "null"):
+		add("cors", sevMedium, "Access-Control-Allow-Origin null")
+	case acao != "" && acac:
+		add("cors", sevInfo, "credentials allowed for origin "+acao)
+	}
+
+	resp := http.Response{Header: header}
+	for _, c := range resp.Cookies() {
+		for _, f := range auditCookie(pageURL, c, isHTTPS) {
+			add("cookie", f.Severity, f.Finding)
+		}
+	}
+	return findings
+}
+
+func parseCSP(csp string) map[string][]string {
+	directives := map[string][]string{}
+	for _, part := range strings.Split(csp, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		name := strings.ToLower(fields[0])
+		if _, ok := directives[name]; !ok {
+			directives[name] = fields[1:]
+		}
+	}
+	return directives
+}
+
+func auditCSP(csp string) []headerFinding {
+	findings := []headerFinding{}
+	directives := parseCSP(csp)
+
+	scriptSrc, ok := directives["script-src"]
+	name := "script-src"
+	if !ok {
+		scriptSrc, ok = directives["default-src"]
+		name = "default-src"
+	}
+	if !ok {
+		findings = append(findings, headerFinding{"csp", sevMedium, "no script-src or default-src"})
+	}
+
+	hasNonce := false
+	for _, src := range scriptSrc {
+		s := strings.ToLower(src)
+		if strings.HasPrefix(s, "'nonce-") || strings.HasPrefix(s, "'sha") || s == "'strict-dynamic'" {
+			hasNonce = true
+		}
+	}
+	for _, src := range scriptSrc {
+		s := strings.ToLower(src)
+		switch {
+		case s == "'unsafe-inline'" && !hasNonce:
+			findings = append(findings, headerFinding{"csp", sevMedium, name + " allows 'unsafe-inline'"})
+		case s == "'unsafe-eval'":
+			findings = append(findings, headerFinding{"csp", sevLow, name + " allows 'unsafe-eval'"})
+		case s == "*" || s == "http:" || s == "https:" || s == "data:":
+			findings = append(findings, headerFinding{"csp", sevMedium, name + " allows wildcard source " + src})
+		case strings.Contains(s, "*"):
+			findings = append(findings, headerFinding{"csp", sevLow, name + " allows wildcard host " + src})
+		}
+	}
+
+	if _, ok := directives["object-src"]; !ok {
+		if srcs, ok := directives["default-src"]; !ok || !crawlbase.ContainsString(srcs, "'none'") {
+			findings = append(findings, headerFinding{"csp", sevLow, "object-src not restricted"})
+		}
+	}
+	if _, ok := directives["base-uri"]; !ok {
+		findings = append(findings, headerFinding{"csp", sevInfo, "missing base-uri"})
+	}
+	return findings
+}
+
+func auditHSTS(hsts string) []headerFinding {
+	findings := []headerFinding{}
+	maxAge := -1
+	subDomains, preload := false, false
+	for _, part := range strings.Split(hsts, ";") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch {
+		case strings.HasPrefix(part, "max-age="):
+			maxAge, _ = strconv.Atoi(strings.Trim(strings.TrimPrefix(part, "max-age="), `"`))
+		case part == "includesubdomains":
+			subDomains = true
+		case part == "preload":
+			preload = true
+		}
+	}
+
+	switch {
+	case maxAge < 0:
+		findings = append(findings, headerFinding{"hsts", sevMedium, "Strict-Transport-Security without max-age"})
+	case maxAge == 0:
+		findings = append(findings, headerFinding{"hsts", sevMedium, "Strict-Transport-Security max-age=0 disables hsts"})
+	case maxAge < 15552000:
+		findings = append(findings, headerFinding{"hsts", sevLow, "Strict-Transport-Security max-age below 180 days"})
+	}
+	if !subDomains {
+		findings = append(findings, headerFinding{"hsts", sevInfo, "Strict-Transport-Security without includeSubDomains"})
+	}
+	if !preload {
+		findings = append(findings, headerFinding{"hsts", sevInfo, "Strict-Transport-Security without preload"})
+	} else if !subDomains || maxAge < 31536000 {
+		findings = append(findings, headerFinding{"hsts", sevLow, "preload requires includeSubDomains and max-age of one year"})
+	}
+	return findings
+}
+
+func auditCookie(pageURL *url.URL, c *http.Cookie, isHTTPS bool) []headerFinding {
+	findings := []headerFinding{}
+	add := func(severity, finding string) {
+		findings = append(findings, headerFinding{"cookie", severity, "cookie " + c.Name + " " + finding})
+	}
+	if !c.Secure {
+		if isHTTPS {
+			add(sevMedium, "without Secure")
+		} else {
+			add(sevLow, "set over http without Secure")
+		}
+	}
+	if !c.HttpOnly {
+		add(sevLow, "without HttpOnly")
+	}
+	switch c.SameSite {
+	case 0, http.SameSiteDefaultMode:
+		add(sevLow, "without SameSite")
+	case http.SameSiteNoneMode:
+		add(sevInfo, "with SameSite=None")
+	}
+	if c.Domain != "" {
+		domain := strings.TrimPrefix(strings.ToLower(c.Domain), ".")
+		host := strings.ToLower(pageURL.Hostname())
+		if domain != host {
+			add(sevInfo, "scoped to domain "+domain)
+		}
+	}
+	return findings
+}
+
+func genReportSecurityHeaders(settings *reportSettings, pageReports map[string]*pageReport) {
+	findings := map[string]*hostFinding{}
+
+	for _, p := range pageReports {
+		if p.Header == nil {
+			continue
+		}
+		pURL, err := url.Parse(p.URL)
+		if err != nil {
+			continue
+		}
+		for _, f := range auditHeaders(pURL, p.Header, p.ContentType) {
+			key := pURL.Host + "\x00" + f.Check + "\x00" + f.Finding
+			hf, ok := findings[key]
+			if !ok {
+				hf = &hostFinding{Host: pURL.Host, headerFinding: f}
+				findings[key] = hf
+			}
+			hf.Count++
+			if hf.ExampleURL == "" || p.URL < hf.ExampleURL {
+				hf.ExampleURL = p.URL
+			}
+		}
+	}
+
+	sorted := []*hostFinding{}
+	for _, hf := range findings {
+		sorted = append(sorted, hf)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		if a.Severity != b.Severity {
+			return severityOrder[a.Severity] < severityOrder[b.Severity]
+		}
+		if a.Check != b.Check {
+			return a.Check < b.Check
+		}
+		return a.Finding < b.Finding
+	})
+
+	path := settings.ReportFile + "/securityheaders.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"host", "severity", "check", "finding", "responses", "example url"})
+	for _, hf := range sorted {
+		csv.Write([]string{hf.Host, hf.Severity, hf.Check, hf.Finding,
+			strconv.Itoa(hf.Count), hf.ExampleURL})
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}
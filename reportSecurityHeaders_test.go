@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func findingTexts(findings []headerFinding) []string {
+	texts := []string{}
+	for _, f := range findings {
+		texts = append(texts, f.Severity+" "+f.Finding)
+	}
+	return texts
+}
+
+func TestAuditCSP(t *testing.T) {
+	tests := []struct {
+		name string
+		csp  string
+		want []string
+	}{
+		{"strict", "default-src 'self'; object-src 'none'; base-uri 'none'", []string{}},
+		{"object-src from default-src", "default-src 'none'; base-uri 'none'", []string{}},
+		{"directive case", "SCRIPT-SRC 'self'; OBJECT-SRC 'none'; BASE-URI 'self'", []string{}},
+		{
+			"unsafe-inline",
+			"script-src 'self' 'unsafe-inline'; object-src 'none'; base-uri 'self'",
+			[]string{"medium script-src allows 'unsafe-inline'"},
+		},
+		{"unsafe-inline with nonce", "script-src 'nonce-abc' 'unsafe-inline'; object-src 'none'; base-uri 'self'", []string{}},
+		{
+			"wildcard host",
+			"script-src https://*.cdn.example.com; object-src 'none'; base-uri 'self'",
+			[]string{"low script-src allows wildcard host https://*.cdn.example.com"},
+		},
+		{
+			"wildcard and unsafe-eval",
+			"default-src * 'unsafe-eval'",
+			[]string{
+				"medium default-src allows wildcard source *",
+				"low default-src allows 'unsafe-eval'",
+				"low object-src not restricted",
+				"info missing base-uri",
+			},
+		},
+		{
+			"no script sources",
+			"img-src 'self'",
+			[]string{
+				"medium no script-src or default-src",
+				"low object-src not restricted",
+				"info missing base-uri",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingTexts(auditCSP(tt.csp)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditCSP(%q) = %q, want %q", tt.csp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditHSTS(t *testing.T) {
+	tests := []struct {
+		name string
+		hsts string
+		want []string
+	}{
+		{"preloaded", "max-age=63072000; includeSubDomains; preload", []string{}},
+		{"quoted max-age", `max-age="31536000"; includesubdomains; preload`, []string{}},
+		{
+			"empty",
+			"",
+			[]string{
+				"medium Strict-Transport-Security without max-age",
+				"info Strict-Transport-Security without includeSubDomains",
+				"info Strict-Transport-Security without preload",
+			},
+		},
+		{
+			"disabled",
+			"max-age=0",
+			[]string{
+				"medium Strict-Transport-Security max-age=0 disables hsts",
+				"info Strict-Transport-Security without includeSubDomains",
+				"info Strict-Transport-Security without preload",
+			},
+		},
+		{
+			"short max-age",
+			"max-age=86400; includeSubDomains",
+			[]string{
+				"low Strict-Transport-Security max-age below 180 days",
+				"info Strict-Transport-Security without preload",
+			},
+		},
+		{
+			"preload below one year",
+			"max-age=15552000; includeSubDomains; preload",
+			[]string{"low preload requires includeSubDomains and max-age of one year"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingTexts(auditHSTS(tt.hsts)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditHSTS(%q) = %q, want %q", tt.hsts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditCookie(t *testing.T) {
+	pageURL, _ := url.Parse("https://www.example.com/")
+	tests := []struct {
+		name    string
+		cookie  http.Cookie
+		isHTTPS bool
+		want    []string
+	}{
+		{"all flags", http.Cookie{Name: "sid", Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode}, true, []string{}},
+		{
+			"no flags over https",
+			http.Cookie{Name: "sid"},
+			true,
+			[]string{"medium cookie sid without Secure", "low cookie sid without HttpOnly", "low cookie sid without SameSite"},
+		},
+		{
+			"no flags over http",
+			http.Cookie{Name: "sid"},
+			false,
+			[]string{"low cookie sid set over http without Secure", "low cookie sid without HttpOnly", "low cookie sid without SameSite"},
+		},
+		{
+			"SameSite=None",
+			http.Cookie{Name: "sid", Secure: true, HttpOnly: true, SameSite: http.SameSiteNoneMode},
+			true,
+			[]string{"info cookie sid with SameSite=None"},
+		},
+		{
+			"parent domain",
+			http.Cookie{Name: "sid", Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode, Domain: ".example.com"},
+			true,
+			[]string{"info cookie sid scoped to domain example.com"},
+		},
+		{
+			"own host",
+			http.Cookie{Name: "sid", Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode, Domain: "WWW.example.com"},
+			true,
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := findingTexts(auditCookie(pageURL, &tt.cookie, tt.isHTTPS)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("auditCookie() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
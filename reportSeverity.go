@@ -0,0 +1,14 @@
+package main
+
+// severities of report findings
+const (
+	sevHigh   = "high"
+	sevMedium = "medium"
+	sevLow    = "low"
+	sevInfo   = "info"
+)
+
+// sevIgnore drops validation errors from the report
+const sevIgnore = "ignore"
+
+var severityOrder = map[string]int{sevHigh: 0, sevMedium: 1, sevLow: 2, sevInfo: 3}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+)
+
+// technologyRule is an entry of config/technologies.json. Patterns are
+// regular expressions, the first group is used as version. Like in
+// Wappalyzer a pattern can end with \;confidence:50. A cookie name ending
+// with * matches all cookies with that prefix, e.g. ASPSESSIONID*.
+type technologyRule struct {
+	Name     string
+	Category string
+	Headers  map[string]string
+	Cookies  map[string]string
+	Meta     map[string]string
+	Scripts  []string
+	Body     []string
+}
+
+type techPattern struct {
+	Key        string // lower case header, cookie or meta name
+	Regex      *regexp.Regexp
+	Confidence int
+}
+
+// technology holds the compiled patterns of a rule. Named patterns are
+// sorted by name, so the first version found doesn't depend on map order.
+type technology struct {
+	Name     string
+	Category string
+	Headers  []*techPattern
+	Cookies  []*techPattern
+	Meta     []*techPattern
+	Scripts  []*techPattern
+	Body     []*techPattern
+}
+
+type techMatch struct {
+	Name       string
+	Category   string
+	Version    string
+	Confidence int
+	Evidence   string
+}
+
+var regMetaTag = regexp.MustCompile(`(?i)<meta\s[^>]*>`)
+var regMetaName = regexp.MustCompile(`(?i)\bname\s*=\s*["']([^"']+)["']`)
+var regMetaContent = regexp.MustCompile(`(?i)\bcontent\s*=\s*["']([^"']*)["']`)
+
+func loadTechnologies(path string) ([]*technology, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules := []*technologyRule{}
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	techs := []*technology{}
+	for _, r := range rules {
+		t := &technology{Name: r.Name, Category: r.Category}
+		if t.Headers, err = compileTechPatternMap(r.Headers); err != nil {
+			return nil, err
+		}
+		if t.Cookies, err = compileTechPatternMap(r.Cookies); err != nil {
+			return nil, err
+		}
+		if t.Meta, err = compileTechPatternMap(r.Meta); err != nil {
+			return nil, err
+		}
+		if t.Scripts, err = compileTechPatterns(r.Scripts); err != nil {
+			return nil, err
+		}
+		if t.Body, err = compileTechPatterns(r.Body); err != nil {
+			return nil, err
+		}
+		techs = append(techs, t)
+	}
+	return techs, nil
+}
+
+func compileTechPattern(pattern string) (*techPattern, error) {
+	p := &techPattern{Confidence: 100}
+	parts := strings.Split(pattern, `\;`)
+	for _, tag := range parts[1:] {
+		if strings.HasPrefix(tag, "confidence:") {
+			c, err := strconv.Atoi(strings.TrimPrefix(tag, "confidence:"))
+			if err == nil {
+				p.Confidence = c
+			}
+		}
+	}
+	regex, err := regexp.Compile("(?i)" + parts[0])
+	if err != nil {
+		return nil, err
+	}
+	p.Regex = regex
+	return p, nil
+}
+
+func compileTechPatterns(patterns []string) ([]*techPattern, error) {
+	compiled := []*techPattern{}
+	for _, pattern := range patterns {
+		p, err := compileTechPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+func compileTechPatternMap(patterns map[string]string) ([]*techPattern, error) {
+	compiled := []*techPattern{}
+	for key, pattern := range patterns {
+		p, err := compileTechPattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		p.Key = strings.ToLower(key)
+		compiled = append(compiled, p)
+	}
+	sort.Slice(compiled, func(i, j int) bool {
+		return compiled[i].Key < compiled[j].Key
+	})
+	return compiled, nil
+}
+
+// cookieValues returns the values of the cookies matching a lower case rule
+// name, a trailing * matches a prefix.
+func cookieValues(cookies map[string]string, name string) []string {
+	if !strings.HasSuffix(name, "*") {
+		if val, ok := cookies[name]; ok {
+			return []string{val}
+		}
+		return nil
+	}
+	names := []string{}
+	prefix := strings.TrimSuffix(name, "*")
+	for cookie := range cookies {
+		if strings.HasPrefix(cookie, prefix) {
+			names = append(names, cookie)
+		}
+	}
+	sort.Strings(names)
+	values := []string{}
+	for _, cookie := range names {
+		values = append(values, cookies[cookie])
+	}
+	return values
+}
+
+// fingerprintPage evaluates all technologies on a stored page.
+func fingerprintPage(techs []*technology, page *crawlbase.Page) []*techMatch {
+	if page.Response == nil {
+		return nil
+	}
+
+	headers := map[string][]string{}
+	for k, v := range page.Response.Header {
+		headers[strings.ToLower(k)] = v
+	}
+	cookies := map[string]string{}
+	resp := http.Response{Header: page.Response.Header}
+	for _, c := range resp.Cookies() {
+		cookies[strings.ToLower(c.Name)] = c.Value
+	}
+
+	var body string
+	metas := map[string][]string{}
+	mime := crawlbase.GetContentMime(page.Response.Header)
+	if isTextMime(mime) {
+		body = string(page.ResponseBody)
+	}
+	if mime == "text/html" {
+		for _, tag := range regMetaTag.FindAllString(body, -1) {
+			name := regMetaName.FindStringSubmatch(tag)
+			content := regMetaContent.FindStringSubmatch(tag)
+			if name != nil && content != nil {
+				key := strings.ToLower(name[1])
+				metas[key] = append(metas[key], content[1])
+			}
+		}
+	}
+
+	scripts := []string{}
+	for _, res := range page.RespInfo.Ressources {
+		if res.Tag == "script" && res.Url != "" {
+			scripts = append(scripts, res.Url)
+		}
+	}
+
+	matches := []*techMatch{}
+	for _, t := range techs {
+		m := &techMatch{Name: t.Name, Category: t.Category, Evidence: page.URL}
+		test := func(p *techPattern, text string) {
+			found := p.Regex.FindStringSubmatch(text)
+			if found == nil {
+				return
+			}
+			m.Confidence += p.Confidence
+			if m.Version == "" && len(found) > 1 {
+				m.Version = found[1]
+			}
+		}
+
+		for _, p := range t.Headers {
+			for _, val := range headers[p.Key] {
+				test(p, val)
+			}
+		}
+		for _, p := range t.Cookies {
+			for _, val := range cookieValues(cookies, p.Key) {
+				test(p, val)
+			}
+		}
+		for _, p := range t.Meta {
+			for _, val := range metas[p.Key] {
+				test(p, val)
+			}
+		}
+		for _, p := range t.Scripts {
+			for _, script := range scripts {
+				test(p, script)
+			}
+		}
+		if body != "" {
+			for _, p := range t.Body {
+				test(p, body)
+			}
+		}
+
+		if m.Confidence > 0 {
+			if m.Confidence > 100 {
+				m.Confidence = 100
+			}
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+func isTextMime(mime string) bool {
+	return strings.HasPrefix(mime, "text/") ||
+		strings.Contains(mime, "javascript") ||
+		strings.Contains(mime, "json") ||
+		strings.Contains(mime, "xml")
+}
+
+func genReportTechnologies(settings *reportSettings, pageReports map[string]*pageReport) {
+	path := settings.ReportFile + "/technologies.csv"
+	err := removeIfExists(path)
+	checkError(err)
+
+	// host -> technology + version -> best match
+	hosts := map[string]map[string]*techMatch{}
+	for _, p := range pageReports {
+		pURL, err := url.Parse(p.URL)
+		if err != nil {
+			continue
+		}
+		techs, ok := hosts[pURL.Host]
+		if !ok {
+			techs = map[string]*techMatch{}
+			hosts[pURL.Host] = techs
+		}
+		for _, m := range p.Technologies {
+			key := m.Name + "\x00" + m.Version
+			prev, ok := techs[key]
+			if !ok || m.Confidence > prev.Confidence ||
+				(m.Confidence == prev.Confidence && m.Evidence < prev.Evidence) {
+				techs[key] = m
+			}
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"host", "technology", "category", "version",
+		"confidence", "evidence url"})
+
+	hostNames := []string{}
+	for host := range hosts {
+		hostNames = append(hostNames, host)
+	}
+	sort.Strings(hostNames)
+
+	for _, host := range hostNames {
+		matches := []*techMatch{}
+		for _, m := range hosts[host] {
+			matches = append(matches, m)
+		}
+		sort.Slice(matches, func(i, j int) bool {
+			if matches[i].Name != matches[j].Name {
+				return matches[i].Name < matches[j].Name
+			}
+			return matches[i].Version < matches[j].Version
+		})
+		for _, m := range matches {
+			csv.Write([]string{host, m.Name, m.Category, m.Version,
+				strconv.Itoa(m.Confidence), m.Evidence})
+		}
+	}
+
+	csv.Flush()
+	checkError(csv.Error())
+}
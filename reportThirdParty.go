@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BlackEspresso/crawlbase"
+	"github.com/PuerkitoBio/goquery"
+)
+
+// resource kinds
+const (
+	resScript = "script"
+	resStyle  = "style"
+	resIframe = "iframe"
+	resImage  = "image"
+	resMedia  = "media"
+	resObject = "object"
+	resLink   = "link"
+	resAnchor = "anchor"
+)
+
+// pageResource is a resource loaded by a page, Integrity is set if the
+// tag has a subresource integrity hash.
+type pageResource struct {
+	URL       string
+	Kind      string
+	Integrity bool
+}
+
+var resourceSelectors = []struct {
+	Selector string
+	Attr     string
+	Kind     string
+}{
+	{"iframe[src]", "src", resIframe},
+	{"frame[src]", "src", resIframe},
+	{"video[src]", "src", resMedia},
+	{"audio[src]", "src", resMedia},
+	{"source[src]", "src", resMedia},
+	{"object[data]", "data", resObject},
+	{"embed[src]", "src", resObject},
+}
+
+func ressourceKind(r crawlbase.Ressource) string {
+	switch r.Tag {
+	case "script":
+		return resScript
+	case "img":
+		return resImage
+	case "style":
+		return resStyle
+	case "link":
+		if strings.Contains(strings.ToLower(r.Rel), "stylesheet") {
+			return resStyle
+		}
+		if strings.Contains(strings.ToLower(r.Rel), "icon") {
+			return resImage
+		}
+	}
+	return resLink
+}
+
+// getPageResources merges the ressources found by the crawler with iframes,
+// media and objects and the integrity attributes of scripts and links.
+func getPageResources(page *crawlbase.Page, doc *goquery.Document, base *url.URL) []pageResource {
+	resources := []pageResource{}
+	seen := map[string]bool{}
+	add := func(r pageResource) {
+		if r.URL == "" || strings.HasPrefix(r.URL, "data:") || seen[r.Kind+r.URL] {
+			return
+		}
+		seen[r.Kind+r.URL] = true
+		resources = append(resources, r)
+	}
+
+	integrity := map[string]bool{}
+	if doc != nil {
+		doc.Find("script[integrity], link[integrity]").Each(func(i int, s *goquery.Selection) {
+			src, ok := s.Attr("src")
+			if !ok {
+				src, _ = s.Attr("href")
+			}
+			if v, _ := s.Attr("integrity"); strings.TrimSpace(v) != "" {
+				integrity[crawlbase.ToAbsUrl(base, src)] = true
+			}
+		})
+		for _, sel := range resourceSelectors {
+			doc.Find(sel.Selector).Each(func(i int, s *goquery.Selection) {
+				src, _ := s.Attr(sel.Attr)
+				add(pageResource{crawlbase.ToAbsUrl(base, src), sel.Kind, false})
+			})
+		}
+	}
+
+	for _, r := range page.RespInfo.Ressources {
+		add(pageResource{r.Url, ressourceKind(r), integrity[r.Url]})
+	}
+	return resources
+}
+
+type domainUse struct {
+	Domain string
+	Kinds  map[string]int
+	Pages  map[string]bool
+	Mixed  int
+	NoSRI  int
+}
+
+func genReportThirdParty(settings *reportSettings, pageReports map[string]*pageReport) {
+	hosts := map[string]bool{}
+	for u := range pageReports {
+		if pURL, err := url.Parse(u); err == nil {
+			hosts[pURL.Host] = true
+		}
+	}
+
+	urls := []string{}
+	for u := range pageReports {
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	path := settings.ReportFile + "/thirdparty.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	csv.Write([]string{"domain", "kind", "resource", "page", "third party", "mixed content", "missing sri"})
+
+	domains := map[string]*domainUse{}
+	for _, u := range urls {
+		p := pageReports[u]
+		pURL, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		resources := p.Resources
+		for _, href := range sortedKeys(p.Hrefs) {
+			resources = append(resources, pageResource{href, resAnchor, false})
+		}
+
+		for _, r := range resources {
+			rURL, err := url.Parse(r.URL)
+			if err != nil || (rURL.Scheme != "http" && rURL.Scheme != "https") {
+				continue
+			}
+			thirdParty := !hosts[rURL.Host]
+			mixed := pURL.Scheme == "https" && rURL.Scheme == "http" && r.Kind != resAnchor
+			noSRI := thirdParty && !r.Integrity && (r.Kind == resScript || r.Kind == resStyle)
+			if !thirdParty && !mixed {
+				continue
+			}
+
+			csv.Write([]string{rURL.Host, r.Kind, r.URL, u, strconv.FormatBool(thirdParty),
+				strconv.FormatBool(mixed), strconv.FormatBool(noSRI)})
+
+			if !thirdParty {
+				continue
+			}
+			d, ok := domains[rURL.Host]
+			if !ok {
+				d = &domainUse{Domain: rURL.Host, Kinds: map[string]int{}, Pages: map[string]bool{}}
+				domains[rURL.Host] = d
+			}
+			d.Kinds[r.Kind]++
+			d.Pages[u] = true
+			if mixed {
+				d.Mixed++
+			}
+			if noSRI {
+				d.NoSRI++
+			}
+		}
+	}
+	csv.Flush()
+	checkError(csv.Error())
+
+	genReportThirdPartyDomains(settings, domains)
+}
+
+func genReportThirdPartyDomains(settings *reportSettings, domains map[string]*domainUse) {
+	sorted := []*domainUse{}
+	for _, d := range domains {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].Pages) != len(sorted[j].Pages) {
+			return len(sorted[i].Pages) > len(sorted[j].Pages)
+		}
+		return sorted[i].Domain < sorted[j].Domain
+	})
+
+	path := settings.ReportFile + "/thirdparty_domains.csv"
+	err := removeIfExists(path)
+	checkError(err)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0655)
+	checkError(err)
+	defer file.Close()
+
+	kinds := []string{resScript, resStyle, resIframe, resImage, resMedia, resObject, resLink, resAnchor}
+
+	csv := csv.NewWriter(file)
+	csv.Comma = ';'
+	header := []string{"domain", "pages"}
+	header = append(header, kinds...)
+	header = append(header, "mixed content", "missing sri")
+	csv.Write(header)
+	for _, d := range sorted {
+		row := []string{d.Domain, strconv.Itoa(len(d.Pages))}
+		for _, k := range kinds {
+			row = append(row, strconv.Itoa(d.Kinds[k]))
+		}
+		row = append(row, strconv.Itoa(d.Mixed), strconv.Itoa(d.NoSRI))
+		csv.Write(row)
+	}
+	csv.Flush()
+	checkError(csv.Error())
+}